@@ -0,0 +1,94 @@
+// Package backend adapts backup storage to io/fs so that every storage type
+// shares the validator's single code path. A backend is an fs.FS; optional
+// capability interfaces let the validator use features the storage has:
+//
+//   - io.ReaderAt on opened files, or RangeFS, for random access
+//     (format inspection and forensic hexdumps)
+//   - MetadataFS for storage-side metadata such as recorded digests,
+//     WORM retention, and the file's location on local disk (bad-block
+//     mapping)
+//
+// Local disk, NetApp SnapLock volumes and tar archives are provided here;
+// archive/zip readers are already an fs.FS and need no adapter.
+package backend
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// RangeFS is implemented by backends that can stream part of a file without
+// reading it from the start, such as object stores with ranged GETs.
+type RangeFS interface {
+	fs.FS
+	// OpenRange opens up to length bytes of name starting at offset.
+	OpenRange(name string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Metadata is storage-side information about a file.
+type Metadata struct {
+	// LocalPath is the file's path on local disk, if it has one.
+	LocalPath string
+	// MD5 is the hex content digest recorded by the storage, if known.
+	MD5 string
+	// WORM reports that the storage has committed the file to
+	// write-once-read-many protection.
+	WORM bool
+	// RetainUntil is when the storage will next allow the file to be
+	// modified or deleted. It is zero if unknown.
+	RetainUntil time.Time
+	// LegalHold reports a hold that blocks deletion regardless of
+	// RetainUntil.
+	LegalHold bool
+	// Attributes holds any other backend-specific metadata.
+	Attributes map[string]string
+}
+
+// MetadataFS is implemented by backends that can report Metadata.
+type MetadataFS interface {
+	fs.FS
+	Metadata(name string) (Metadata, error)
+}
+
+// ReaderAt returns random access to file, which was opened as name from
+// fsys. It uses the file's own ReadAt if it has one, falls back to ranged
+// reads from fsys, and returns nil if neither is available.
+func ReaderAt(fsys fs.FS, name string, file fs.File) io.ReaderAt {
+	if ra, ok := file.(io.ReaderAt); ok {
+		return ra
+	}
+	if rfs, ok := fsys.(RangeFS); ok {
+		return rangeReaderAt{rfs, name}
+	}
+	return nil
+}
+
+// StatMetadata returns the Metadata for name if fsys implements MetadataFS,
+// and the zero Metadata otherwise.
+func StatMetadata(fsys fs.FS, name string) (Metadata, error) {
+	if mfs, ok := fsys.(MetadataFS); ok {
+		return mfs.Metadata(name)
+	}
+	return Metadata{}, nil
+}
+
+type rangeReaderAt struct {
+	fsys RangeFS
+	name string
+}
+
+func (r rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	rc, err := r.fsys.OpenRange(r.name, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	n, err := io.ReadFull(rc, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
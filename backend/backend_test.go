@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// rangeOnlyFS serves files that cannot seek, plus ranged reads.
+type rangeOnlyFS struct {
+	fstest.MapFS
+}
+
+func (r rangeOnlyFS) Open(name string) (fs.File, error) {
+	f, err := r.MapFS.Open(name)
+	return struct{ fs.File }{f}, err
+}
+
+func (r rangeOnlyFS) OpenRange(name string, offset, length int64) (io.ReadCloser, error) {
+	data := r.MapFS[name].Data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return io.NopCloser(io.LimitReader(bytes.NewReader(data[offset:]), length)), nil
+}
+
+func TestReaderAt(t *testing.T) {
+	fsys := rangeOnlyFS{fstest.MapFS{"a": {Data: []byte("0123456789")}}}
+	f, _ := fsys.Open("a")
+	ra := ReaderAt(fsys, "a", f)
+	if ra == nil {
+		t.Fatal("expected ranged random access")
+	}
+
+	buf := make([]byte, 4)
+	if n, err := ra.ReadAt(buf, 3); n != 4 || err != nil || string(buf) != "3456" {
+		t.Errorf("ReadAt(3) = %d, %v, %q", n, err, buf[:n])
+	}
+	if n, err := ra.ReadAt(buf, 8); n != 2 || err != io.EOF || string(buf[:n]) != "89" {
+		t.Errorf("ReadAt(8) = %d, %v, %q; want 2, EOF, \"89\"", n, err, buf[:n])
+	}
+
+	if ReaderAt(fsys.MapFS, "a", f) != nil {
+		t.Error("expected no random access without RangeFS")
+	}
+}
+
+func TestLocalMetadata(t *testing.T) {
+	dir := t.TempDir()
+	meta, err := StatMetadata(NewLocal(dir), "daily/db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "daily", "db.sql"); meta.LocalPath != want {
+		t.Errorf("LocalPath = %q, want %q", meta.LocalPath, want)
+	}
+	if _, err := StatMetadata(NewLocal(dir), "../etc/passwd"); err == nil {
+		t.Error("expected an error for a path outside the root")
+	}
+}
+
+func TestSnapLockMetadata(t *testing.T) {
+	dir := t.TempDir()
+	retainUntil := time.Date(2033, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, mode := range map[string]os.FileMode{"locked": 0o444, "open": 0o644} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, retainUntil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := NewSnapLock(dir)
+	meta, err := StatMetadata(fsys, "locked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.WORM {
+		t.Error("locked: expected WORM")
+	}
+	if runtime.GOOS == "linux" && !meta.RetainUntil.Equal(retainUntil) {
+		t.Errorf("locked: RetainUntil = %v, want %v", meta.RetainUntil, retainUntil)
+	}
+
+	meta, err = StatMetadata(fsys, "open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.WORM || !meta.RetainUntil.IsZero() {
+		t.Errorf("open: got WORM %v, RetainUntil %v; want neither", meta.WORM, meta.RetainUntil)
+	}
+}
@@ -0,0 +1,26 @@
+package backend
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Local is a directory tree on local disk.
+type Local struct {
+	fs.FS
+	root string
+}
+
+// NewLocal returns a backend rooted at the directory root.
+func NewLocal(root string) *Local {
+	return &Local{FS: os.DirFS(root), root: root}
+}
+
+// Metadata reports the file's path on local disk.
+func (l *Local) Metadata(name string) (Metadata, error) {
+	if !fs.ValidPath(name) {
+		return Metadata{}, &fs.PathError{Op: "metadata", Path: name, Err: fs.ErrInvalid}
+	}
+	return Metadata{LocalPath: filepath.Join(l.root, filepath.FromSlash(name))}, nil
+}
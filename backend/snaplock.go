@@ -0,0 +1,35 @@
+package backend
+
+import "os"
+
+// SnapLock is a NetApp SnapLock volume mounted over NFS. SnapLock exposes
+// WORM state through ordinary file attributes: a file is committed by
+// removing its write permissions, and its retention date is stored as the
+// file's access time. Legal holds are only visible through the ONTAP API
+// and are not reported.
+type SnapLock struct {
+	*Local
+}
+
+// NewSnapLock returns a backend for the SnapLock volume mounted at root.
+func NewSnapLock(root string) *SnapLock {
+	return &SnapLock{NewLocal(root)}
+}
+
+// Metadata reports the file's local path, WORM state and retention date.
+func (s *SnapLock) Metadata(name string) (Metadata, error) {
+	meta, err := s.Local.Metadata(name)
+	if err != nil {
+		return meta, err
+	}
+	info, err := os.Stat(meta.LocalPath)
+	if err != nil {
+		return meta, err
+	}
+
+	meta.WORM = info.Mode()&0o222 == 0
+	if meta.WORM {
+		meta.RetainUntil = accessTime(info)
+	}
+	return meta, nil
+}
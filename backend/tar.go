@@ -0,0 +1,180 @@
+package backend
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tar is the contents of an uncompressed tar archive. Member data is read
+// in place, so every file supports random access. Regular files and hard
+// links are exposed; symlinks and device nodes have no content to validate
+// and are left out.
+type Tar struct {
+	r     io.ReaderAt
+	nodes map[string]*tarNode
+}
+
+type tarNode struct {
+	info     fs.FileInfo
+	offset   int64
+	children []fs.DirEntry
+}
+
+// NewTar indexes the tar archive in r. An archive that ends part-way
+// through a member is reported as an error.
+func NewTar(r io.ReaderAt, size int64) (*Tar, error) {
+	t := &Tar{r: r, nodes: map[string]*tarNode{".": {info: dirInfo(".")}}}
+
+	cr := &countingReader{r: io.NewSectionReader(r, 0, size)}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, errors.New("tar: archive is truncated")
+			}
+			return nil, fmt.Errorf("tar: %w", err)
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("tar: member name %q cannot be represented", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			t.add(name, &tarNode{info: hdr.FileInfo()})
+		case tar.TypeReg:
+			if cr.n+hdr.Size > size {
+				return nil, fmt.Errorf("tar: member %q is truncated", name)
+			}
+			t.add(name, &tarNode{info: hdr.FileInfo(), offset: cr.n})
+		case tar.TypeLink:
+			target, ok := t.nodes[path.Clean(strings.TrimPrefix(hdr.Linkname, "/"))]
+			if !ok || target.info.IsDir() {
+				return nil, fmt.Errorf("tar: hard link %q has no target %q", name, hdr.Linkname)
+			}
+			t.add(name, &tarNode{info: renamedInfo{target.info, path.Base(name)}, offset: target.offset})
+		case tar.TypeGNUSparse:
+			return nil, fmt.Errorf("tar: sparse member %q is not supported", name)
+		}
+	}
+
+	for _, n := range t.nodes {
+		sort.Slice(n.children, func(i, j int) bool { return n.children[i].Name() < n.children[j].Name() })
+	}
+	return t, nil
+}
+
+// add records node at name, creating missing parent directories.
+func (t *Tar) add(name string, node *tarNode) {
+	if old, ok := t.nodes[name]; ok {
+		// A later member with the same name replaces the earlier one.
+		node.children = old.children
+		t.nodes[name] = node
+		parent := t.nodes[path.Dir(name)]
+		for i, e := range parent.children {
+			if e.Name() == path.Base(name) {
+				parent.children[i] = fs.FileInfoToDirEntry(node.info)
+			}
+		}
+		return
+	}
+
+	parentName := path.Dir(name)
+	if _, ok := t.nodes[parentName]; !ok {
+		t.add(parentName, &tarNode{info: dirInfo(path.Base(parentName))})
+	}
+	t.nodes[name] = node
+	parent := t.nodes[parentName]
+	parent.children = append(parent.children, fs.FileInfoToDirEntry(node.info))
+}
+
+// Open opens the named member.
+func (t *Tar) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	node, ok := t.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.info.IsDir() {
+		return &tarDir{node: node}, nil
+	}
+	return &tarFile{
+		info:          node.info,
+		SectionReader: io.NewSectionReader(t.r, node.offset, node.info.Size()),
+	}, nil
+}
+
+type tarFile struct {
+	info fs.FileInfo
+	*io.SectionReader
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *tarFile) Close() error               { return nil }
+
+type tarDir struct {
+	node *tarNode
+	pos  int
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.node.info, nil }
+func (d *tarDir) Close() error               { return nil }
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.node.children[d.pos:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.pos += len(rest)
+	return append([]fs.DirEntry(nil), rest...), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// dirInfo describes a directory implied by member paths.
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o755 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() any           { return nil }
+
+// renamedInfo gives a hard link its own name.
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (r renamedInfo) Name() string { return r.name }
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func buildTar(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	add := func(hdr *tar.Header, data string) {
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(data))
+	}
+	add(&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755}, "")
+	add(&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0o644}, "127.0.0.1 localhost\n")
+	add(&tar.Header{Name: "var/lib/db/" + strings.Repeat("long", 30), Typeflag: tar.TypeReg, Mode: 0o600}, "rows")
+	add(&tar.Header{Name: "etc/hosts.bak", Typeflag: tar.TypeLink, Linkname: "etc/hosts"}, "")
+	add(&tar.Header{Name: "etc/localtime", Typeflag: tar.TypeSymlink, Linkname: "/usr/share/zoneinfo/UTC"}, "")
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestTar(t *testing.T) {
+	data := buildTar(t)
+	tfs, err := NewTar(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := "var/lib/db/" + strings.Repeat("long", 30)
+	if err := fstest.TestFS(tfs, "etc/hosts", "etc/hosts.bak", long); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"etc/hosts":     "127.0.0.1 localhost\n",
+		"etc/hosts.bak": "127.0.0.1 localhost\n",
+		long:            "rows",
+	} {
+		got, err := fs.ReadFile(tfs, name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := fs.Stat(tfs, "etc/localtime"); err == nil {
+		t.Error("symlink member should not be exposed")
+	}
+
+	f, _ := tfs.Open("etc/hosts")
+	if _, ok := f.(io.ReaderAt); !ok {
+		t.Error("tar members should support random access")
+	}
+}
+
+func TestTarTruncated(t *testing.T) {
+	data := buildTar(t)
+	for _, n := range []int{100, 1030, 1536 + 10} {
+		if _, err := NewTar(bytes.NewReader(data[:n]), int64(n)); err == nil || !strings.Contains(err.Error(), "truncated") {
+			t.Errorf("archive cut at %d: got %v, want truncation error", n, err)
+		}
+	}
+}
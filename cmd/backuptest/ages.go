@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"backuptest/history"
+
+	"github.com/fatih/color"
+)
+
+const year = 365 * 24 * time.Hour
+
+// runAges implements "backuptest ages", which reports how long files have
+// been unchanged across recorded runs and lists archive-tier candidates.
+func runAges(ctx context.Context, args []string) int {
+	fset := flag.NewFlagSet("ages", flag.ExitOnError)
+	dsn := fset.String("history", os.Getenv("BACKUPTEST_HISTORY"), "history store to read (env BACKUPTEST_HISTORY)")
+	target := fset.String("target", "", "only use runs of this backup path")
+	years := fset.Float64("years", 1, "list files unchanged for at least this many years")
+	fset.Parse(args)
+
+	if *dsn == "" || fset.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: backuptest ages [-history dsn] [-target path] [-years n]")
+		return 1
+	}
+
+	var include func(history.Summary) bool
+	if *target != "" {
+		abs, err := filepath.Abs(*target)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+			return 1
+		}
+		include = func(s history.Summary) bool { return s.Target == abs }
+	}
+
+	store, err := history.Open(*dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+		return 1
+	}
+	defer store.Close()
+
+	ages, err := history.Ages(ctx, store, include)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+		return 1
+	}
+
+	now := time.Now()
+	threshold := time.Duration(*years * float64(year))
+	var candidates int
+	var candidateSize int64
+	earliest := false
+
+	fmt.Println(color.CyanString("\n=== FILES UNCHANGED FOR %g+ YEARS ===\n", *years))
+	for _, a := range ages {
+		if a.Age(now) < threshold {
+			continue
+		}
+		candidates++
+		candidateSize += a.Size
+
+		marker := " "
+		if a.SinceFirstRecord {
+			marker = "*"
+			earliest = true
+		}
+		fmt.Printf("  %s%s  %8s  %s\n",
+			a.UnchangedSince.Local().Format("2006-01-02"),
+			marker,
+			formatSize(a.Size),
+			a.Path,
+		)
+	}
+	if earliest {
+		fmt.Println("\n  * unchanged since the first run that recorded it; the content may be older")
+	}
+
+	fmt.Println(color.CyanString("\n=== SUMMARY ==="))
+	fmt.Printf("  Files tracked: %d\n", len(ages))
+	fmt.Printf("  Archive-tier candidates: %d (%s)\n", candidates, formatSize(candidateSize))
+	return 0
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"backuptest/history"
+	"backuptest/validator"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// storageTimeout bounds how long doctor waits for the backup storage,
+	// since a hung NFS mount blocks instead of failing.
+	storageTimeout = 10 * time.Second
+	// maxClockSkew is the largest difference tolerated between this host's
+	// clock and the history storage's.
+	maxClockSkew = 2 * time.Minute
+)
+
+// doctor collects the outcomes of environment checks.
+type doctor struct {
+	ok, warnings, failures int
+}
+
+func (d *doctor) pass(name, detail string) {
+	d.ok++
+	fmt.Printf("[%s] %s: %s\n", color.GreenString("OK"), name, detail)
+}
+
+func (d *doctor) warn(name, detail, fix string) {
+	d.warnings++
+	fmt.Printf("[%s] %s: %s\n", color.YellowString("WARNING"), name, detail)
+	fmt.Printf("    Fix: %s\n", fix)
+}
+
+func (d *doctor) fail(name string, err error, fix string) {
+	d.failures++
+	fmt.Printf("[%s] %s: %v\n", color.RedString("FAIL"), name, err)
+	fmt.Printf("    Fix: %s\n", fix)
+}
+
+// runDoctor implements "backuptest doctor", which checks that a validation
+// run with the given options can work on this host.
+func runDoctor(ctx context.Context, args []string) int {
+	flag.Usage = usage
+	flag.CommandLine.Parse(args)
+	if flag.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: backuptest doctor [options] [backup_path]")
+		return 1
+	}
+	backupPath := flag.Arg(0)
+
+	d := &doctor{}
+	fmt.Println(color.CyanString("\n=== ENVIRONMENT CHECK ===\n"))
+
+	d.checkOptions()
+	if backupPath != "" {
+		d.checkStorage(ctx, backupPath)
+	}
+	if *badBlocks {
+		d.checkBadBlocks(backupPath)
+	}
+	d.checkHistory(ctx, *historyDSN)
+	d.checkClock(ctx, *historyDSN)
+
+	fmt.Println(color.CyanString("\n=== SUMMARY ==="))
+	fmt.Printf("  OK: %d\n", d.ok)
+	fmt.Printf("  Warnings: %d\n", d.warnings)
+	fmt.Printf("  Failures: %d\n", d.failures)
+	if d.failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (d *doctor) checkOptions() {
+	if *retention != "" {
+		if _, err := retentionOption(); err != nil {
+			d.fail("Options", err, "give -retention as a duration such as 90d, 7y or 2160h, together with -snaplock and without -archive")
+			return
+		}
+	}
+	for _, key := range strings.Split(*retiredKeys, ",") {
+		if key != "" && !strings.Contains(key, ":") && len(strings.ReplaceAll(key, " ", "")) < 8 {
+			d.warn("Options", fmt.Sprintf("retired key %q is too short to match anything", key),
+				"give OpenPGP keys as at least 8 hex digits of the key ID, or the full fingerprint")
+			return
+		}
+	}
+	d.pass("Options", "valid")
+}
+
+// checkStorage checks that the backup can be read, without hanging on
+// unresponsive network storage.
+func (d *doctor) checkStorage(ctx context.Context, backupPath string) {
+	type outcome struct {
+		detail string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		info, err := os.Stat(backupPath)
+		if err != nil {
+			done <- outcome{err: err}
+			return
+		}
+		if info.IsDir() {
+			entries, err := os.ReadDir(backupPath)
+			done <- outcome{fmt.Sprintf("%s is readable (%d entries)", backupPath, len(entries)), err}
+			return
+		}
+		f, err := os.Open(backupPath)
+		if err == nil {
+			_, err = f.Read(make([]byte, 4096))
+			f.Close()
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		done <- outcome{fmt.Sprintf("%s is readable (%s)", backupPath, formatSize(info.Size())), err}
+	}()
+
+	var o outcome
+	select {
+	case o = <-done:
+	case <-time.After(storageTimeout):
+		d.fail("Storage", fmt.Errorf("%s did not respond within %s", backupPath, storageTimeout),
+			"check that the storage is mounted and its server is reachable (e.g. mount, showmount -e, ping)")
+		return
+	case <-ctx.Done():
+		d.fail("Storage", ctx.Err(), "let the check finish")
+		return
+	}
+
+	switch {
+	case errors.Is(o.err, fs.ErrNotExist):
+		d.fail("Storage", o.err, "check the path, and that the backup storage is mounted")
+	case errors.Is(o.err, fs.ErrPermission):
+		d.fail("Storage", o.err, "run backuptest as a user that can read the backup, or grant read access")
+	case o.err != nil:
+		d.fail("Storage", o.err, "check the storage and the kernel log (dmesg) for I/O errors")
+	case *archive && !isArchivePath(backupPath):
+		d.fail("Storage", fmt.Errorf("-archive is set but %s is not a .tar or .zip file", backupPath),
+			"drop -archive, or point it at a .tar or .zip file")
+	default:
+		d.pass("Storage", o.detail)
+	}
+}
+
+func isArchivePath(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".tar" || ext == ".zip"
+}
+
+func (d *doctor) checkBadBlocks(backupPath string) {
+	if backupPath == "" {
+		d.warn("Bad-block mapping", "not checked without a backup path",
+			"run backuptest doctor -badblocks <backup_path>")
+		return
+	}
+	dir := backupPath
+	if info, err := os.Stat(backupPath); err == nil && !info.IsDir() {
+		dir = filepath.Dir(backupPath)
+	}
+
+	err := validator.CheckBadBlocks(dir)
+	switch {
+	case err == nil:
+		d.pass("Bad-block mapping", "block device and kernel log are accessible")
+	case errors.Is(err, fs.ErrPermission):
+		d.fail("Bad-block mapping", err,
+			"run as root or with CAP_SYSLOG, or set sysctl kernel.dmesg_restrict=0, so the kernel log can be read")
+	case strings.Contains(err.Error(), "sysfs"):
+		d.fail("Bad-block mapping", err,
+			"the backup is not on a local block device (e.g. NFS or FUSE); run backuptest on the storage host")
+	default:
+		d.fail("Bad-block mapping", err, "drop -badblocks on this host")
+	}
+}
+
+func (d *doctor) checkHistory(ctx context.Context, dsn string) {
+	if dsn == "" {
+		d.warn("History", "no history store is set, so runs are not recorded",
+			"set -history or BACKUPTEST_HISTORY, e.g. to /var/lib/backuptest/history.db")
+		return
+	}
+
+	// Opening a store creates it, which a check must not do.
+	path, dir, local := historyPath(dsn)
+	if local {
+		if _, err := os.Stat(path); err != nil {
+			fix := "check the path, or record a first run to create the store"
+			if errors.Is(err, fs.ErrNotExist) && history.Scheme(dsn) == "jsonl" {
+				fix = "check the path, or create the directory (mkdir -p " + path + ")"
+			}
+			d.fail("History", err, fix)
+			return
+		}
+	}
+
+	store, err := history.Open(checkDSN(dsn))
+	if err != nil {
+		d.fail("History", err, "check the DSN; backends: "+strings.Join(history.Backends(), ", "))
+		return
+	}
+	defer store.Close()
+
+	runs, err := store.List(ctx)
+	if err != nil {
+		fix := "move the run file named in the error out of the store"
+		if history.Scheme(dsn) != "jsonl" {
+			fix = "check that the DSN names a backuptest history store"
+		}
+		d.fail("History", err, fix)
+		return
+	}
+
+	detail := fmt.Sprintf("%d runs recorded", len(runs))
+	if local {
+		// SQLite writes its journal beside the database, so both the file
+		// and its directory must be writable.
+		f, err := os.CreateTemp(dir, ".doctor-*")
+		if err == nil {
+			f.Close()
+			os.Remove(f.Name())
+			if path != dir {
+				if f, err = os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+					f.Close()
+				}
+			}
+		}
+		if err != nil {
+			d.fail("History", err, "grant the user running backuptest write access to "+path)
+			return
+		}
+		detail += ", writable"
+	}
+	d.pass("History", detail)
+}
+
+// checkClock looks for a clock that is behind the build, behind recorded
+// runs, or out of step with the history storage. Any of these corrupts
+// run ordering and file ages.
+func (d *doctor) checkClock(ctx context.Context, dsn string) {
+	const fix = "synchronize the clock with NTP (timedatectl, chronyc tracking)"
+	now := time.Now()
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key != "vcs.time" {
+				continue
+			}
+			if built, err := time.Parse(time.RFC3339, s.Value); err == nil && now.Before(built) {
+				d.fail("Clock", fmt.Errorf("clock reads %s, before this build was committed (%s)",
+					now.Format(time.RFC3339), built.Format(time.RFC3339)), fix)
+				return
+			}
+		}
+	}
+
+	// A missing store is reported by checkHistory, and must not be created.
+	path, dir, local := historyPath(dsn)
+	if local {
+		if _, err := os.Stat(path); err != nil {
+			dsn = ""
+		}
+	}
+	if dsn != "" {
+		if store, err := history.Open(checkDSN(dsn)); err == nil {
+			runs, err := store.List(ctx)
+			store.Close()
+			if err == nil && len(runs) > 0 {
+				if last := runs[len(runs)-1]; last.Started.After(now) {
+					d.fail("Clock", fmt.Errorf("clock reads %s, before recorded run %s started",
+						now.Format(time.RFC3339), last.ID), fix)
+					return
+				}
+			}
+		}
+
+		// A file's modification time is set by the server on network file
+		// systems, which shows any skew between the two clocks.
+		if local {
+			if skew, err := storageClockSkew(dir, now); err == nil && (skew > maxClockSkew || skew < -maxClockSkew) {
+				d.warn("Clock", fmt.Sprintf("history storage clock differs from this host by %s", skew),
+					"synchronize both this host and the storage server with NTP")
+				return
+			}
+		}
+	}
+	d.pass("Clock", now.Format(time.RFC3339))
+}
+
+// storageClockSkew creates a file in dir and returns how far its
+// modification time is from now.
+func storageClockSkew(dir string, now time.Time) (time.Duration, error) {
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.ModTime().Sub(now).Round(time.Second), nil
+}
+
+// historyPath returns the file or directory named by a history store DSN
+// on the local file system, and the directory the store writes files in.
+func historyPath(dsn string) (path, dir string, ok bool) {
+	switch history.Scheme(dsn) {
+	case "jsonl":
+		path = strings.TrimPrefix(dsn, "jsonl://")
+		return path, path, true
+	case "sqlite":
+		path, _, _ = strings.Cut(strings.TrimPrefix(dsn, "sqlite://"), "?")
+		return path, filepath.Dir(path), true
+	}
+	return "", "", false
+}
+
+// checkDSN returns the DSN the checks open a history store with. SQLite
+// databases are opened read-only, since opening one otherwise writes the
+// schema to it.
+func checkDSN(dsn string) string {
+	if path, _, ok := historyPath(dsn); ok && history.Scheme(dsn) == "sqlite" {
+		return "sqlite://" + path + "?mode=ro"
+	}
+	return dsn
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"backuptest/history"
+)
+
+func TestCheckHistory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := history.Open(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &history.Run{ID: history.NewRunID(time.Now()), Target: "/backup", Started: time.Now()}
+	if err := store.Save(ctx, run); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if err := os.WriteFile(filepath.Join(dir, "empty.db"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		dsn  string
+		ok   bool
+	}{
+		{"sqlite store", filepath.Join(dir, "history.db"), true},
+		{"jsonl store", "jsonl://" + dir, true},
+		{"missing sqlite store", filepath.Join(dir, "missing.db"), false},
+		{"missing jsonl store", "jsonl://" + filepath.Join(dir, "missing"), false},
+		{"empty file", filepath.Join(dir, "empty.db"), false},
+	}
+	for _, tt := range tests {
+		d := &doctor{}
+		d.checkHistory(ctx, tt.dsn)
+		if ok := d.failures == 0; ok != tt.ok {
+			t.Errorf("%s: passed = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+
+	// Checking must not create or write to a store.
+	for _, name := range []string{"missing.db", "missing"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was created: %v", name, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "empty.db")); err != nil || info.Size() != 0 {
+		t.Errorf("empty.db was written to: %v, %v", info, err)
+	}
+}
+
+func TestCheckHistoryUnwritable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions do not apply to root")
+	}
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	db := filepath.Join(dir, "history.db")
+	store, err := history.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if err := os.Chmod(db, 0o444); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonlDir := t.TempDir()
+	if err := os.Chmod(jsonlDir, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(jsonlDir, 0o755)
+
+	for _, dsn := range []string{db, "jsonl://" + jsonlDir} {
+		d := &doctor{}
+		d.checkHistory(ctx, dsn)
+		if d.failures != 1 {
+			t.Errorf("%s: got %d failures, want 1 for an unwritable store", dsn, d.failures)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"backuptest/validator"
+
+	"github.com/fatih/color"
+)
+
+// Kinds of fault injected by faultgen, in the order they are used.
+const (
+	faultBitFlip  = "bit flip"
+	faultTruncate = "truncation"
+	faultDelete   = "deletion"
+	faultSwap     = "swapped contents"
+)
+
+var faultKinds = []string{faultBitFlip, faultTruncate, faultDelete, faultSwap}
+
+// fault is one injected corruption and how the validation run reported it.
+type fault struct {
+	kind     string
+	paths    []string
+	detail   string
+	detected bool
+	// notes say, for each affected path, what the run reported or that it
+	// reported nothing.
+	notes []string
+}
+
+// faultRun is the outcome of injecting faults into a tree.
+type faultRun struct {
+	files      int
+	faults     []fault
+	unexpected []string
+}
+
+// runFaultgen implements "backuptest faultgen", a developer tool that
+// corrupts a copy of a backup tree in known ways and checks which faults a
+// validation run of the copy detects.
+func runFaultgen(ctx context.Context, args []string) int {
+	fset := flag.NewFlagSet("faultgen", flag.ExitOnError)
+	faults := fset.Int("faults", 8, "number of faults to inject, cycling through bit flips, truncations, deletions and swaps")
+	seed := fset.Int64("seed", 0, "random seed, to repeat an earlier run (default: time-based)")
+	keep := fset.Bool("keep", false, "keep the corrupted copy for inspection")
+	fset.Parse(args)
+
+	if fset.NArg() != 1 || *faults < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: backuptest faultgen [-faults n] [-seed n] [-keep] <backup_dir>")
+		return 1
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+
+	fail := func(err error) int {
+		fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+		return 1
+	}
+
+	dir, err := os.MkdirTemp("", "backuptest-faultgen-")
+	if err != nil {
+		return fail(err)
+	}
+	if *keep {
+		defer fmt.Printf("\nCorrupted copy kept at %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	if err := copyTree(fset.Arg(0), dir); err != nil {
+		return fail(err)
+	}
+
+	run, err := injectFaults(ctx, dir, rng, *faults, faultKinds)
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Println(color.CyanString("\n=== FAULT INJECTION ===\n"))
+	fmt.Printf("  Seed: %d | Files: %d | Faults: %d\n\n", *seed, run.files, len(run.faults))
+
+	var missed int
+	for _, f := range run.faults {
+		status := color.GreenString("DETECTED")
+		if !f.detected {
+			status = color.RedString("MISSED")
+			missed++
+		}
+		fmt.Printf("[%s] %s: %s\n", status, f.kind, f.detail)
+		for _, note := range f.notes {
+			fmt.Printf("    %s\n", note)
+		}
+	}
+	for _, u := range run.unexpected {
+		fmt.Printf("[%s] %s\n", color.RedString("UNEXPECTED"), u)
+	}
+
+	fmt.Println(color.CyanString("\n=== SUMMARY ==="))
+	fmt.Printf("  Faults injected: %d\n", len(run.faults))
+	fmt.Printf("  Detected: %d\n", len(run.faults)-missed)
+	fmt.Printf("  Missed: %d\n", missed)
+	fmt.Printf("  Unexpected failures: %d\n", len(run.unexpected))
+	if missed > 0 || len(run.unexpected) > 0 {
+		return 1
+	}
+	fmt.Println(color.GreenString("\n✓ Every injected fault was detected"))
+	return 0
+}
+
+// injectFaults validates the tree in dir, injects up to n faults into it,
+// using kinds in turn, and validates it again exactly as "backuptest dir"
+// would. A fault counts as detected only if that run reports every file it
+// affected as failed. dir is modified.
+func injectFaults(ctx context.Context, dir string, rng *rand.Rand, n int, kinds []string) (*faultRun, error) {
+	v := validator.New()
+
+	// Validate the pristine tree to learn which files pass.
+	baseline := validateBackup(ctx, v, dir, false, false)
+	passed := make(map[string]bool)
+	var candidates []validator.Result
+	for _, r := range baseline {
+		if r.Status == validator.StatusOK {
+			passed[r.Path] = true
+			candidates = append(candidates, r)
+		}
+	}
+	rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+
+	run := &faultRun{files: len(baseline)}
+	for i := 0; i < n && len(candidates) > 0; i++ {
+		f, rest, err := injectFault(rng, dir, kinds[i%len(kinds)], candidates)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			continue
+		}
+		run.faults = append(run.faults, *f)
+		candidates = rest
+	}
+	if len(run.faults) == 0 {
+		return nil, errors.New("no files in the tree validated cleanly, so no faults could be injected")
+	}
+
+	after := validateBackup(ctx, v, dir, false, false)
+	results := make(map[string]validator.Result, len(after))
+	for _, r := range after {
+		results[r.Path] = r
+	}
+
+	faulted := make(map[string]bool)
+	for i := range run.faults {
+		f := &run.faults[i]
+		f.detected = true
+		for _, p := range f.paths {
+			faulted[p] = true
+			r, ok := results[p]
+			switch {
+			case ok && r.Status != validator.StatusOK:
+				f.notes = append(f.notes, fmt.Sprintf("%s: %s: %s", p, r.Status, r.Error))
+			case !ok:
+				// A run only sees the files that are there.
+				f.detected = false
+				f.notes = append(f.notes, p+": not reported; the run has no record that it existed")
+			default:
+				f.detected = false
+				f.notes = append(f.notes, p+": validated as OK")
+			}
+		}
+	}
+
+	// Files that were not touched must still pass.
+	for _, r := range after {
+		if !faulted[r.Path] && passed[r.Path] && r.Status != validator.StatusOK {
+			run.unexpected = append(run.unexpected, fmt.Sprintf("%s: %s", r.Path, r.Error))
+		}
+	}
+	sort.Strings(run.unexpected)
+	return run, nil
+}
+
+// injectFault applies a fault of the given kind to files taken from the
+// front of candidates, and returns the candidates left. It returns a nil
+// fault if kind needs more files than remain.
+func injectFault(rng *rand.Rand, dir, kind string, candidates []validator.Result) (*fault, []validator.Result, error) {
+	r := candidates[0]
+	path := filepath.Join(dir, filepath.FromSlash(r.Path))
+
+	switch kind {
+	case faultBitFlip:
+		offset := rng.Int63n(r.Size)
+		bit := uint(rng.Intn(8))
+		if err := flipBit(path, offset, bit); err != nil {
+			return nil, nil, err
+		}
+		return &fault{kind: kind, paths: []string{r.Path}, detail: fmt.Sprintf("%s (byte %d, bit %d)", r.Path, offset, bit)}, candidates[1:], nil
+
+	case faultTruncate:
+		size := rng.Int63n(r.Size)
+		if err := os.Truncate(path, size); err != nil {
+			return nil, nil, err
+		}
+		return &fault{kind: kind, paths: []string{r.Path}, detail: fmt.Sprintf("%s (%d of %d bytes kept)", r.Path, size, r.Size)}, candidates[1:], nil
+
+	case faultDelete:
+		if err := os.Remove(path); err != nil {
+			return nil, nil, err
+		}
+		return &fault{kind: kind, paths: []string{r.Path}, detail: r.Path}, candidates[1:], nil
+
+	case faultSwap:
+		// Files with identical content cannot be told apart once swapped.
+		for i := 1; i < len(candidates); i++ {
+			o := candidates[i]
+			if o.Checksum == r.Checksum {
+				continue
+			}
+			if err := swapContents(path, filepath.Join(dir, filepath.FromSlash(o.Path))); err != nil {
+				return nil, nil, err
+			}
+			rest := append(candidates[1:i:i], candidates[i+1:]...)
+			return &fault{kind: kind, paths: []string{r.Path, o.Path}, detail: r.Path + " <-> " + o.Path}, rest, nil
+		}
+		return nil, candidates, nil
+	}
+	return nil, nil, fmt.Errorf("unknown fault kind %q", kind)
+}
+
+func flipBit(path string, offset int64, bit uint) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		f.Close()
+		return err
+	}
+	b[0] ^= 1 << bit
+	if _, err := f.WriteAt(b, offset); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func swapContents(a, b string) error {
+	dataA, err := os.ReadFile(a)
+	if err != nil {
+		return err
+	}
+	dataB, err := os.ReadFile(b)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(a, dataB, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(b, dataA, 0o644)
+}
+
+// copyTree copies the directories and regular files under src into dst.
+func copyTree(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type().IsRegular():
+			return copyFile(path, target)
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestInjectFaults(t *testing.T) {
+	ctx := context.Background()
+	// A NetCDF classic header without dimensions, attributes or variables,
+	// so that any truncation cuts into it.
+	netcdf := "CDF\x01" + strings.Repeat("\x00", 28)
+	text := map[string]string{
+		"a.txt": "first backup file\n",
+		"b.txt": "second backup file\n",
+	}
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		kind     string
+		detected bool
+		note     string
+	}{
+		{"truncated NetCDF", map[string]string{"a.nc": netcdf, "b.nc": netcdf}, faultTruncate, true, ""},
+		// Local storage records no digests, so nothing is compared with the
+		// content of files whose format is not checked.
+		{"bit flip in text", text, faultBitFlip, false, "validated as OK"},
+		{"truncated text", text, faultTruncate, false, "validated as OK"},
+		{"swapped text", text, faultSwap, false, "validated as OK"},
+		{"deletion", text, faultDelete, false, "no record"},
+	}
+	for _, tt := range tests {
+		dir := writeTree(t, tt.files)
+		run, err := injectFaults(ctx, dir, rand.New(rand.NewSource(1)), 2, []string{tt.kind})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(run.faults) == 0 {
+			t.Fatalf("%s: no faults injected", tt.name)
+		}
+		for _, f := range run.faults {
+			if f.detected != tt.detected || !strings.Contains(strings.Join(f.notes, "\n"), tt.note) {
+				t.Errorf("%s: %s: detected = %v (%v), want %v (%q)", tt.name, f.detail, f.detected, f.notes, tt.detected, tt.note)
+			}
+		}
+		if len(run.unexpected) != 0 {
+			t.Errorf("%s: unexpected failures %v", tt.name, run.unexpected)
+		}
+	}
+}
+
+func TestFaultgenReportsMisses(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"a.txt": "first backup file\n",
+		"b.txt": "second backup file\n",
+		"c.txt": "third backup file\n",
+	})
+	if code := runFaultgen(context.Background(), []string{"-seed", "1", dir}); code != 1 {
+		t.Errorf("faultgen exited %d on faults a run cannot see, want 1", code)
+	}
+	// The tree given is left as it was.
+	if data, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(data) != "first backup file\n" {
+		t.Errorf("a.txt = %q, %v; want it unchanged", data, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"backuptest/history"
+
+	"github.com/fatih/color"
+)
+
+// runFind implements "backuptest find", which searches the paths and
+// archive members recorded by indexed runs.
+func runFind(ctx context.Context, args []string) int {
+	fset := flag.NewFlagSet("find", flag.ExitOnError)
+	dsn := fset.String("history", os.Getenv("BACKUPTEST_HISTORY"), "history store to search (env BACKUPTEST_HISTORY)")
+	limit := fset.Int("limit", 10, "matches to show per run (0 for all)")
+	fset.Parse(args)
+
+	if *dsn == "" || fset.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: backuptest find [-history dsn] [-limit n] <pattern>")
+		return 1
+	}
+	pattern := fset.Arg(0)
+
+	store, err := history.Open(*dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+		return 1
+	}
+	defer store.Close()
+
+	fmt.Println(color.CyanString("\n=== SEARCH: %s ===\n", pattern))
+
+	found := 0
+	err = history.Search(ctx, store, pattern, func(run history.Summary, matches []history.Match) error {
+		found++
+		fmt.Printf("%s  %s  %s (%d matches)\n",
+			color.HiWhiteString(run.ID),
+			run.Started.Local().Format("2006-01-02 15:04:05"),
+			run.Target,
+			len(matches),
+		)
+		for i, m := range matches {
+			if *limit > 0 && i == *limit {
+				fmt.Printf("    ... %d more\n", len(matches)-i)
+				break
+			}
+			if m.Member != "" {
+				fmt.Printf("    %s: %s\n", m.Path, m.Member)
+			} else {
+				fmt.Printf("    %s\n", m.Path)
+			}
+		}
+		fmt.Println()
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+		return 1
+	}
+
+	if found == 0 {
+		fmt.Println("No recorded run contains a match.")
+		return 1
+	}
+	fmt.Printf("Found in %d runs.\n", found)
+	return 0
+}
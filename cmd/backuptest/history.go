@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"backuptest/history"
+	_ "backuptest/history/postgres"
+	_ "backuptest/history/s3"
+	_ "backuptest/history/sqlite"
+	"backuptest/validator"
+
+	"github.com/fatih/color"
+)
+
+// recordRun saves run to the history store named by dsn. The target is
+// stored as an absolute path so runs can be compared later.
+func recordRun(ctx context.Context, dsn string, run *history.Run) error {
+	if abs, err := filepath.Abs(run.Target); err == nil {
+		run.Target = abs
+	}
+
+	store, err := history.Open(dsn)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.Save(ctx, run)
+}
+
+// runHistory implements "backuptest history", which lists recorded runs.
+func runHistory(ctx context.Context, args []string) int {
+	fset := flag.NewFlagSet("history", flag.ExitOnError)
+	dsn := fset.String("history", os.Getenv("BACKUPTEST_HISTORY"), "history store to read (env BACKUPTEST_HISTORY)")
+	fset.Parse(args)
+
+	if *dsn == "" {
+		fmt.Fprintln(os.Stderr, "Usage: backuptest history -history <dsn>")
+		return 1
+	}
+
+	store, err := history.Open(*dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+		return 1
+	}
+	defer store.Close()
+
+	runs, err := store.List(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+		return 1
+	}
+
+	fmt.Println(color.CyanString("\n=== RUN HISTORY ===\n"))
+	for _, s := range runs {
+		fmt.Printf("%s  %s  %s\n", color.HiWhiteString(s.ID), s.Started.Local().Format("2006-01-02 15:04:05"), s.Target)
+		fmt.Printf("    Valid: %d | Warnings: %d | Flaky: %d | Errors: %d | Duration: %s\n",
+			s.Counts[validator.StatusOK],
+			s.Counts[validator.StatusWarning],
+			s.Counts[validator.StatusFlaky],
+			s.Counts[validator.StatusError],
+			s.Finished.Sub(s.Started).Round(time.Millisecond),
+		)
+		if p := s.Provenance; p != nil && p.RerunOf != "" {
+			fmt.Printf("    Rerun of: %s\n", p.RerunOf)
+		}
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs recorded.")
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"backuptest/validator"
+
+	"github.com/fatih/color"
+)
+
+// displayKeys groups encrypted files by the keys they can be decrypted
+// with, for key-rotation audits. It prints nothing if no file is encrypted.
+func displayKeys(results []validator.Result) {
+	files := make(map[string]int)
+	retired := make(map[string]bool)
+	var encrypted, plain int
+	for _, r := range results {
+		enc := r.Encryption
+		if enc == nil {
+			if r.Checksum != "" {
+				plain++
+			}
+			continue
+		}
+		encrypted++
+		for _, key := range enc.Keys {
+			files[enc.Format+" "+key]++
+		}
+		for _, key := range enc.Retired {
+			retired[enc.Format+" "+key] = true
+		}
+		if enc.Anonymous > 0 {
+			files[enc.Format+" (anonymous recipient)"]++
+		}
+		if enc.Passphrase {
+			files[enc.Format+" (passphrase)"]++
+		}
+	}
+	if encrypted == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Println(color.CyanString("\n=== ENCRYPTION KEYS ===\n"))
+	for _, key := range keys {
+		unit := "files"
+		if files[key] == 1 {
+			unit = "file"
+		}
+		line := fmt.Sprintf("  %-40s %6d %s", key, files[key], unit)
+		if retired[key] {
+			line = color.RedString(line + "  RETIRED")
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("\n  Encrypted: %d | Not encrypted: %d\n", encrypted, plain)
+}
+
+// formatEncryption describes enc on one line.
+func formatEncryption(enc *validator.Encryption) string {
+	recipients := append([]string(nil), enc.Keys...)
+	if enc.Anonymous > 0 {
+		recipients = append(recipients, fmt.Sprintf("%d anonymous", enc.Anonymous))
+	}
+	if enc.Passphrase {
+		recipients = append(recipients, "passphrase")
+	}
+	return fmt.Sprintf("%s | recipients: %s", enc.Format, strings.Join(recipients, ", "))
+}
@@ -1,27 +1,44 @@
 package main
 
 import (
-	"os/signal"
-	"syscall"
+	"archive/zip"
 	"context"
-	"crypto/md5"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"backuptest/backend"
+	"backuptest/history"
+	"backuptest/validator"
+
 	"github.com/fatih/color"
 )
 
-type BackupResult struct {
-	BackupPath string
-	Size       int64
-	Checksum   string
-	Status     string
-	Error      string
-	TestTime   time.Time
-}
+// Options of the validation command. They are package-level so that
+// "backuptest rerun" can apply a recorded configuration to them.
+var (
+	recheck           = flag.Bool("recheck", true, "re-validate failed files once at the end of the run")
+	recheckDelay      = flag.Duration("recheck-delay", 5*time.Second, "delay before re-validating failed files")
+	hexdump           = flag.Bool("hexdump", false, "include a hexdump of the region around read failures")
+	badBlocks         = flag.Bool("badblocks", false, "map read failures to device sectors and kernel I/O errors (Linux)")
+	archive           = flag.Bool("archive", false, "validate the members of a .tar or .zip file instead of the file itself")
+	index             = flag.Bool("index", false, "record archive member names so recorded runs can be searched with find")
+	snapLock          = flag.Bool("snaplock", false, "read WORM state and retention dates as a NetApp SnapLock volume exposes them over NFS")
+	requireEncryption = flag.Bool("require-encryption", false, "fail files that are not encrypted with OpenPGP or age")
+	retiredKeys       = flag.String("retired-keys", "", "comma-separated OpenPGP key IDs/fingerprints or age recipients that must no longer be in use")
+	retention         = flag.String("retention", "", "require every file to be WORM-protected and retained for at least this long (e.g. 90d, 7y; needs -snaplock)")
+	historyDSN        = flag.String("history", os.Getenv("BACKUPTEST_HISTORY"), "record the run in this history store (env BACKUPTEST_HISTORY)")
+)
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -35,162 +52,246 @@ func main() {
 		cancel()
 	}()
 
-	if len(os.Args) < 2 {
-		fmt.Println(color.CyanString("backuptest - Backup Integrity Validator"))
-		fmt.Println()
-		fmt.Println("Usage: backuptest <backup_path>")
-		fmt.Println()
-		fmt.Println("Examples:")
-		fmt.Println("  backuptest /backup/daily")
-		fmt.Println("  backuptest /backup/daily/database.sql")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			os.Exit(runHistory(ctx, os.Args[2:]))
+		case "find":
+			os.Exit(runFind(ctx, os.Args[2:]))
+		case "ages":
+			os.Exit(runAges(ctx, os.Args[2:]))
+		case "rerun":
+			os.Exit(runRerun(ctx, os.Args[2:]))
+		case "faultgen":
+			os.Exit(runFaultgen(ctx, os.Args[2:]))
+		case "doctor":
+			os.Exit(runDoctor(ctx, os.Args[2:]))
+		}
+	}
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
 		os.Exit(1)
 	}
 
-	backupPath := os.Args[1]
-	results := validateBackup(ctx, backupPath)
-	displayResults(results)
+	os.Exit(validate(ctx, flag.Arg(0), newProvenance()))
 }
 
-func validateBackup(ctx context.Context, backupPath string) []BackupResult {
-	var results []BackupResult
-
-	select {
-	case <-ctx.Done():
-		results = append(results, BackupResult{
-			BackupPath: backupPath,
-			Status:     "ERROR",
-			Error:      "context cancelled",
-		})
-		return results
-	default:
+// validate runs the validation command on backupPath with the current
+// option values, and records the run if a history store is set.
+func validate(ctx context.Context, backupPath string, prov *history.Provenance) int {
+	var opts []validator.Option
+	if *recheck {
+		opts = append(opts, validator.WithRecheck(*recheckDelay))
 	}
-
-	info, err := os.Stat(backupPath)
-	if err != nil {
-		results = append(results, BackupResult{
-			BackupPath: backupPath,
-			Status:     "ERROR",
-			Error:      err.Error(),
-		})
-		return results
+	if *hexdump {
+		opts = append(opts, validator.WithHexdump())
+	}
+	if *badBlocks {
+		opts = append(opts, validator.WithBadBlocks())
+	}
+	if *index {
+		opts = append(opts, validator.WithIndex())
+	}
+	if *requireEncryption {
+		opts = append(opts, validator.WithRequiredEncryption())
+	}
+	if *retiredKeys != "" {
+		opts = append(opts, validator.WithRetiredKeys(strings.Split(*retiredKeys, ",")...))
+	}
+	if *retention != "" {
+		minimum, err := retentionOption()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+			return 1
+		}
+		opts = append(opts, validator.WithRetention(minimum))
 	}
 
-	if info.IsDir() {
-		// Directory backup - validate all files
-		filepath.Walk(backupPath, func(path string, info os.FileInfo, err error) error {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
-
-			if err != nil {
-				results = append(results, BackupResult{
-					BackupPath: path,
-					Status:     "ERROR",
-					Error:      err.Error(),
-				})
-				return nil
-			}
-
-			if !info.IsDir() {
-				result := validateFile(ctx, path)
-				results = append(results, result)
-			}
-			return nil
-		})
-	} else {
-		// Single file backup
-		results = append(results, validateFile(ctx, backupPath))
+	run := &history.Run{Target: backupPath, Started: time.Now(), Provenance: prov}
+	run.ID = history.NewRunID(run.Started)
+	run.Results = validateBackup(ctx, validator.New(opts...), backupPath, *archive, *snapLock)
+	run.Finished = time.Now()
+	displayResults(backupPath, run.Results)
+	displayProvenance(prov)
+
+	if *historyDSN != "" {
+		if err := recordRun(ctx, *historyDSN, run); err != nil {
+			fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+			return 1
+		}
+		fmt.Printf("\nRun %s recorded\n", run.ID)
 	}
+	return 0
+}
 
-	return results
+func usage() {
+	fmt.Println(color.CyanString("backuptest - Backup Integrity Validator"))
+	fmt.Println()
+	fmt.Println("Usage: backuptest [options] <backup_path>")
+	fmt.Println("       backuptest history [-history dsn]")
+	fmt.Println("       backuptest find [-history dsn] [-limit n] <pattern>")
+	fmt.Println("       backuptest ages [-history dsn] [-target path] [-years n]")
+	fmt.Println("       backuptest rerun [-history dsn] <run_id>")
+	fmt.Println("       backuptest doctor [options] [backup_path]")
+	fmt.Println("       backuptest faultgen [-faults n] [-seed n] [-keep] <backup_dir>")
+	fmt.Println()
+	fmt.Println("Options:")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  backuptest /backup/daily")
+	fmt.Println("  backuptest /backup/daily/database.sql")
+	fmt.Println("  backuptest -recheck-delay 30s /mnt/nfs/backup")
+	fmt.Println("  backuptest -archive /backup/weekly/backup.tar")
+	fmt.Println("  backuptest -index -history /var/lib/backuptest/history.db /backup/weekly")
+	fmt.Println("  backuptest -snaplock -retention 7y /mnt/snaplock/finance")
+	fmt.Println("  backuptest -require-encryption -retired-keys 0F96D516D2EB9062 /backup/offsite")
+	fmt.Println("  backuptest find -history /var/lib/backuptest/history.db 'invoices/2023/Q4/'")
 }
 
-func validateFile(ctx context.Context, filePath string) BackupResult {
-	result := BackupResult{
-		BackupPath: filePath,
-		TestTime:   time.Now(),
+// parseRetention parses a retention period. Besides time.ParseDuration
+// units it accepts whole days ("90d") and years of 365 days ("7y").
+func parseRetention(s string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "y"):
+		unit = year
+	default:
+		return time.ParseDuration(s)
 	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid retention period %q", s)
+	}
+	if n > math.MaxInt64/int64(unit) {
+		return 0, fmt.Errorf("retention period %q is too long", s)
+	}
+	return time.Duration(n) * unit, nil
+}
 
-	select {
-	case <-ctx.Done():
-		result.Status = "ERROR"
-		result.Error = "context cancelled"
-		return result
-	default:
+// retentionOption checks that -retention can be verified with the other
+// options and returns the period it requires. Only -snaplock reports
+// retention, and archive members have none of their own, so any other
+// combination would fail every file.
+func retentionOption() (time.Duration, error) {
+	if !*snapLock || *archive {
+		return 0, errors.New("-retention needs -snaplock and cannot be used with -archive")
+	}
+	minimum, err := parseRetention(*retention)
+	if err == nil && minimum < 0 {
+		err = fmt.Errorf("invalid retention period %q", *retention)
 	}
+	return minimum, err
+}
 
-	// Check file exists and is readable
-	file, err := os.Open(filePath)
-	if err != nil {
-		result.Status = "ERROR"
-		result.Error = err.Error()
-		return result
+// validateBackup validates a backup file, directory tree or archive. Result
+// paths are slash-separated and relative to backupPath; "." is backupPath
+// itself. With snapLock, files on disk are read through backend.SnapLock.
+func validateBackup(ctx context.Context, v *validator.Validator, backupPath string, archive, snapLock bool) []validator.Result {
+	fail := func(err error) []validator.Result {
+		return []validator.Result{{
+			Path:   ".",
+			Status: validator.StatusError,
+			Error:  err.Error(),
+		}}
 	}
-	defer file.Close()
 
-	// Get file size
-	info, err := file.Stat()
-	if err != nil {
-		result.Status = "ERROR"
-		result.Error = err.Error()
-		return result
+	local := func(root string) fs.FS { return backend.NewLocal(root) }
+	if snapLock {
+		local = func(root string) fs.FS { return backend.NewSnapLock(root) }
 	}
-	result.Size = info.Size()
 
-	// Calculate checksum
-	checksum, err := calculateChecksum(ctx, filePath)
+	info, err := os.Stat(backupPath)
 	if err != nil {
-		result.Status = "ERROR"
-		result.Error = err.Error()
-		return result
+		return fail(err)
 	}
-	result.Checksum = checksum
 
-	// Verify file integrity
-	if result.Size == 0 {
-		result.Status = "WARNING"
-		result.Error = "Empty file"
-	} else {
-		result.Status = "OK"
+	var fsys fs.FS
+	switch {
+	case info.IsDir():
+		// Directory backup - validate all files
+		fsys = local(backupPath)
+	case archive:
+		// Archive backup - validate all members
+		afs, closer, err := openArchive(backupPath)
+		if err != nil {
+			return fail(err)
+		}
+		defer closer.Close()
+		fsys = afs
+	default:
+		// Single file backup
+		dir, name := filepath.Split(backupPath)
+		if dir == "" {
+			dir = "."
+		}
+		result := v.ValidateFile(ctx, local(dir), name)
+		result.Path = "."
+		return []validator.Result{result}
 	}
 
-	return result
-}
-
-func calculateChecksum(ctx context.Context, filePath string) (string, error) {
-	file, err := os.Open(filePath)
+	results, err := v.ValidateTree(ctx, fsys).Collect()
 	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
+		results = append(results, validator.Result{
+			Path:   ".",
+			Status: validator.StatusError,
+			Error:  "run interrupted: " + err.Error(),
+		})
 	}
+	return results
+}
 
-	select {
-	case <-ctx.Done():
-		return "", ctx.Err()
+// openArchive opens a .tar or .zip file as a file system.
+func openArchive(archivePath string) (fs.FS, io.Closer, error) {
+	switch strings.ToLower(filepath.Ext(archivePath)) {
+	case ".zip":
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr, nil
+	case ".tar":
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		tfs, err := backend.NewTar(f, info.Size())
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return tfs, f, nil
 	default:
+		return nil, nil, fmt.Errorf("unsupported archive format %q (want .tar or .zip)", filepath.Ext(archivePath))
 	}
-
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
-func displayResults(results []BackupResult) {
+func displayResults(backupPath string, results []validator.Result) {
 	fmt.Println(color.CyanString("\n=== BACKUP INTEGRITY TEST RESULTS ===\n"))
 
-	var ok, warning, errorCount int
+	var ok, warning, flaky, errorCount int
 
 	for _, r := range results {
 		statusColor := color.GreenString
-		if r.Status == "WARNING" {
+		if r.Status == validator.StatusWarning {
 			statusColor = color.YellowString
 			warning++
-		} else if r.Status == "ERROR" {
+		} else if r.Status == validator.StatusFlaky {
+			statusColor = color.MagentaString
+			flaky++
+		} else if r.Status == validator.StatusError {
 			statusColor = color.RedString
 			errorCount++
 		} else {
@@ -198,8 +299,8 @@ func displayResults(results []BackupResult) {
 		}
 
 		fmt.Printf("[%s] %s\n",
-			statusColor(r.Status),
-			r.BackupPath,
+			statusColor(string(r.Status)),
+			filepath.Join(backupPath, filepath.FromSlash(r.Path)),
 		)
 
 		fmt.Printf("    Size: %s | Checksum: %s\n",
@@ -207,15 +308,74 @@ func displayResults(results []BackupResult) {
 			color.HiWhiteString(r.Checksum),
 		)
 
+		if r.Format != "" {
+			fmt.Printf("    Format: %s", r.Format)
+			if r.FormatInfo != "" {
+				fmt.Printf(" | %s", r.FormatInfo)
+			}
+			fmt.Println()
+		}
+
+		if len(r.Members) > 0 {
+			fmt.Printf("    Archive members indexed: %d\n", len(r.Members))
+		}
+
+		if r.Encryption != nil {
+			fmt.Printf("    Encryption: %s\n", formatEncryption(r.Encryption))
+		}
+
+		if r.LegalHold {
+			fmt.Println("    WORM: legal hold")
+		} else if r.RetainUntil != nil {
+			fmt.Printf("    WORM: retained until %s\n", r.RetainUntil.Format(time.DateOnly))
+		}
+
 		if r.Error != "" {
-			fmt.Printf("    %s: %s\n", color.RedString("Error"), r.Error)
+			label := color.RedString("Error")
+			if r.Status == validator.StatusFlaky {
+				label = color.MagentaString("Note")
+			}
+			fmt.Printf("    %s: %s\n", label, r.Error)
+		}
+
+		if f := r.ReadFailure; f != nil {
+			fmt.Printf("    Failed at byte offset: %d", f.Offset)
+			if f.Errno != 0 {
+				fmt.Printf(" | errno: %d", f.Errno)
+			}
+			fmt.Println()
+			for _, line := range strings.Split(strings.TrimSuffix(f.Hexdump, "\n"), "\n") {
+				if line != "" {
+					fmt.Printf("      %s\n", line)
+				}
+			}
+		}
+
+		if b := r.BadBlocks; b != nil {
+			if b.Device != "" {
+				fmt.Printf("    Device: %s | Disk: %s | Partition start sector: %d\n", b.Device, b.Disk, b.StartSector)
+			}
+			for _, region := range b.Regions {
+				sector := "unmapped"
+				if region.DiskSector >= 0 {
+					sector = fmt.Sprintf("%s sector %d", b.Disk, region.DiskSector)
+				}
+				fmt.Printf("    Bad region: file offset %d -> %s | kernel I/O errors: %d\n",
+					region.FileOffset, sector, region.KernelErrors)
+			}
+			if b.Note != "" {
+				fmt.Printf("    %s: %s\n", color.YellowString("Bad-block mapping"), b.Note)
+			}
 		}
 		fmt.Println()
 	}
 
+	displayKeys(results)
+
 	fmt.Println(color.CyanString("\n=== SUMMARY ==="))
 	fmt.Printf("  Valid: %d\n", ok)
 	fmt.Printf("  Warnings: %d\n", warning)
+	fmt.Printf("  Flaky: %d\n", flaky)
 	fmt.Printf("  Errors: %d\n", errorCount)
 
 	if errorCount == 0 && warning == 0 {
@@ -234,4 +394,4 @@ func formatSize(size int64) string {
 		exp++
 	}
 	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
-}
\ No newline at end of file
+}
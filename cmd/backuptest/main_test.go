@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setFlags sets command-line options for the duration of a test.
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+	for name, value := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("no option -%s", name)
+		}
+		name, old := name, f.Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { flag.Set(name, old) })
+	}
+}
+
+func TestRetentionOption(t *testing.T) {
+	tests := []struct {
+		retention string
+		snapLock  bool
+		archive   bool
+		want      time.Duration
+		err       string
+	}{
+		{retention: "90d", snapLock: true, want: 90 * 24 * time.Hour},
+		{retention: "7y", snapLock: true, want: 7 * year},
+		{retention: "2160h", snapLock: true, want: 2160 * time.Hour},
+		{retention: "300y", snapLock: true, err: "too long"},
+		{retention: "-5d", snapLock: true, err: "invalid retention period"},
+		{retention: "-1h", snapLock: true, err: "invalid retention period"},
+		{retention: "7 years", snapLock: true, err: "unknown unit"},
+		{retention: "90d", err: "needs -snaplock"},
+		{retention: "90d", snapLock: true, archive: true, err: "cannot be used with -archive"},
+	}
+	for _, tt := range tests {
+		setFlags(t, map[string]string{
+			"retention": tt.retention,
+			"snaplock":  strconv.FormatBool(tt.snapLock),
+			"archive":   strconv.FormatBool(tt.archive),
+		})
+		got, err := retentionOption()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%+v: unexpected error %v", tt, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%+v: got %v, %v; want error containing %q", tt, got, err, tt.err)
+		case tt.err == "" && got != tt.want:
+			t.Errorf("%+v: got %v, want %v", tt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"backuptest/history"
+
+	"github.com/fatih/color"
+)
+
+// newProvenance describes the current build, host and option values. It
+// must be called after the options have been parsed.
+func newProvenance() *history.Provenance {
+	p := &history.Provenance{
+		Version: "(unknown)",
+		Args:    os.Args[1:],
+		Config:  make(map[string]string),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		p.Version = info.Main.Version
+		var dirty bool
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				p.Commit = s.Value
+			case "vcs.modified":
+				dirty = s.Value == "true"
+			}
+		}
+		if dirty && p.Commit != "" {
+			p.Commit += "-dirty"
+		}
+	}
+	p.Hostname, _ = os.Hostname()
+	flag.VisitAll(func(f *flag.Flag) {
+		p.Config[f.Name] = f.Value.String()
+	})
+	return p
+}
+
+// displayProvenance prints the build, host and configuration of a run.
+func displayProvenance(p *history.Provenance) {
+	fmt.Println(color.CyanString("\n=== RUN CONFIGURATION ==="))
+	build := p.Version
+	if p.Commit != "" {
+		build += " (" + p.Commit + ")"
+	}
+	fmt.Printf("  backuptest %s on %s\n", build, p.Hostname)
+	if p.RerunOf != "" {
+		fmt.Printf("  Rerun of: %s\n", p.RerunOf)
+	}
+
+	names := make([]string, 0, len(p.Config))
+	for name := range p.Config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	opts := make([]string, len(names))
+	for i, name := range names {
+		opts[i] = fmt.Sprintf("-%s=%s", name, p.Config[name])
+	}
+	fmt.Printf("  Options: %s\n", strings.Join(opts, " "))
+}
+
+// runRerun implements "backuptest rerun", which repeats a recorded run on
+// the same target with the options it was recorded with.
+func runRerun(ctx context.Context, args []string) int {
+	fset := flag.NewFlagSet("rerun", flag.ExitOnError)
+	dsn := fset.String("history", os.Getenv("BACKUPTEST_HISTORY"), "history store to read (env BACKUPTEST_HISTORY)")
+	fset.Parse(args)
+
+	if *dsn == "" || fset.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: backuptest rerun [-history dsn] <run_id>")
+		return 1
+	}
+	id := fset.Arg(0)
+
+	store, err := history.Open(*dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+		return 1
+	}
+	run, err := store.Load(ctx, id)
+	store.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s run %s: %v\n", color.RedString("Error:"), id, err)
+		return 1
+	}
+	recorded := run.Provenance
+	if recorded == nil {
+		fmt.Fprintf(os.Stderr, "%s run %s has no recorded configuration\n", color.RedString("Error:"), id)
+		return 1
+	}
+
+	// Options added since the run keep their defaults; options that have
+	// since been removed cannot be applied. The rerun is recorded in the
+	// store it was read from, wherever the original run was recorded from.
+	var notes []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := recorded.Config[f.Name]; !ok && f.Name != "history" {
+			notes = append(notes, fmt.Sprintf("option -%s was not recorded; using %q", f.Name, f.Value.String()))
+		}
+	})
+	for name, value := range recorded.Config {
+		if name == "history" {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			notes = append(notes, fmt.Sprintf("option -%s=%s no longer exists and is ignored", name, value))
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "%s option -%s: %v\n", color.RedString("Error:"), name, err)
+			return 1
+		}
+	}
+	*historyDSN = *dsn
+
+	prov := newProvenance()
+	prov.RerunOf = id
+	if prov.Version != recorded.Version || prov.Commit != recorded.Commit {
+		notes = append(notes, fmt.Sprintf("recorded with backuptest %s %s", recorded.Version, recorded.Commit))
+	}
+	if prov.Hostname != recorded.Hostname {
+		notes = append(notes, "recorded on "+recorded.Hostname)
+	}
+
+	fmt.Printf("Repeating run %s of %s\n", id, run.Target)
+	sort.Strings(notes)
+	for _, note := range notes {
+		fmt.Printf("  %s %s\n", color.YellowString("Note:"), note)
+	}
+	return validate(ctx, run.Target, prov)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backuptest/history"
+)
+
+// saveFlags restores every command-line option when the test ends.
+func saveFlags(t *testing.T) {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	t.Cleanup(func() {
+		for name, value := range values {
+			flag.Set(name, value)
+		}
+	})
+}
+
+func TestRerun(t *testing.T) {
+	ctx := context.Background()
+	saveFlags(t)
+
+	target := t.TempDir()
+	f, err := os.Create(filepath.Join(target, "files.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "invoices/a.pdf", Mode: 0o644, Size: 4})
+	tw.Write([]byte("data"))
+	tw.Close()
+	f.Close()
+
+	// Record a run with non-default options, then move its store, as when
+	// a store is copied to another host to investigate.
+	recorded := filepath.Join(t.TempDir(), "recorded")
+	setFlags(t, map[string]string{"index": "true", "recheck": "false", "history": "jsonl://" + recorded})
+	prov := newProvenance()
+	for name := range prov.Config {
+		// The test binary's own flags share flag.CommandLine.
+		if strings.HasPrefix(name, "test.") {
+			delete(prov.Config, name)
+		}
+	}
+	if code := validate(ctx, target, prov); code != 0 {
+		t.Fatalf("validate exited %d", code)
+	}
+	moved := filepath.Join(t.TempDir(), "moved")
+	if err := copyTree(recorded, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(recorded); err != nil {
+		t.Fatal(err)
+	}
+	setFlags(t, map[string]string{"index": "false", "recheck": "true", "history": ""})
+
+	store, err := history.Open("jsonl://" + moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	runs, err := store.List(ctx)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("List = %v, %v; want the recorded run", runs, err)
+	}
+	original := runs[0].ID
+
+	if code := runRerun(ctx, []string{"-history", "jsonl://" + moved, original}); code != 0 {
+		t.Fatalf("rerun exited %d", code)
+	}
+
+	if _, err := os.Stat(recorded); !os.IsNotExist(err) {
+		t.Errorf("rerun recreated the store the run was first recorded in: %v", err)
+	}
+	runs, err = store.List(ctx)
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("List = %v, %v; want the rerun recorded beside the original", runs, err)
+	}
+	rerun, err := store.Load(ctx, runs[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := rerun.Provenance; p == nil || p.RerunOf != original {
+		t.Errorf("Provenance = %+v, want RerunOf %s", p, original)
+	}
+	if c := rerun.Provenance.Config; c["index"] != "true" || c["recheck"] != "false" || c["history"] != "jsonl://"+moved {
+		t.Errorf("Config = %v, want the recorded options and the store read from", c)
+	}
+	if len(rerun.Results) != 1 || len(rerun.Results[0].Members) == 0 {
+		t.Errorf("Results = %+v, want files.tar indexed as -index was recorded", rerun.Results)
+	}
+}
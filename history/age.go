@@ -0,0 +1,93 @@
+package history
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"backuptest/validator"
+)
+
+// FileAge is how long a file's content has gone unchanged across runs.
+type FileAge struct {
+	Path     string
+	Checksum string
+	Size     int64
+	// UnchangedSince is the start of the earliest run, in the file's
+	// latest unbroken series of runs with the same checksum.
+	UnchangedSince time.Time
+	// SinceFirstRecord is set when that run is the first one that recorded
+	// the file, so its content may be older still.
+	SinceFirstRecord bool
+}
+
+// Age returns how long the file has been unchanged as of now.
+func (a FileAge) Age(now time.Time) time.Duration {
+	return now.Sub(a.UnchangedSince)
+}
+
+// Ages compares the checksums recorded by successive runs and reports, for
+// every file present in the latest of those runs, when its content last
+// changed. Runs are selected by include, or all runs if include is nil.
+// Files that were not validated successfully in a run are ignored for that
+// run, and a file missing from a run does not count as a change. Files are
+// matched by their path within the target; the file of a single-file run
+// is matched by the target's base name.
+func Ages(ctx context.Context, store Store, include func(Summary) bool) ([]FileAge, error) {
+	runs, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type state struct {
+		FileAge
+		lastRun int
+	}
+	files := make(map[string]*state)
+	latest := -1
+
+	for i, sum := range runs {
+		if include != nil && !include(sum) {
+			continue
+		}
+		run, err := store.Load(ctx, sum.ID)
+		if err != nil {
+			return nil, err
+		}
+		latest = i
+
+		for _, r := range run.Results {
+			// Failed results can still carry a checksum, e.g. on a
+			// digest or format error, but it is not trusted content.
+			if r.Status == validator.StatusError || r.Checksum == "" {
+				continue
+			}
+			name := resultPath(run.Target, r)
+			s, ok := files[name]
+			if !ok {
+				s = &state{FileAge: FileAge{Path: name, UnchangedSince: run.Started, SinceFirstRecord: true}}
+				files[name] = s
+			} else if s.Checksum != r.Checksum {
+				s.UnchangedSince = run.Started
+				s.SinceFirstRecord = false
+			}
+			s.Checksum = r.Checksum
+			s.Size = r.Size
+			s.lastRun = i
+		}
+	}
+
+	var ages []FileAge
+	for _, s := range files {
+		if s.lastRun == latest {
+			ages = append(ages, s.FileAge)
+		}
+	}
+	sort.Slice(ages, func(i, j int) bool {
+		if !ages[i].UnchangedSince.Equal(ages[j].UnchangedSince) {
+			return ages[i].UnchangedSince.Before(ages[j].UnchangedSince)
+		}
+		return ages[i].Path < ages[j].Path
+	})
+	return ages, nil
+}
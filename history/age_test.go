@@ -0,0 +1,95 @@
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backuptest/validator"
+)
+
+func TestAges(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	y2020 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	y2022 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	y2024 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ok := func(path, sum string) validator.Result {
+		return validator.Result{Path: path, Checksum: sum, Size: 10, Status: validator.StatusOK}
+	}
+	runs := []*Run{
+		{Target: "/backup", Started: y2020, Results: []validator.Result{
+			ok("static.iso", "aa"), ok("db.sql", "b1"), ok("deleted.log", "cc"),
+		}},
+		{Target: "/backup", Started: y2022, Results: []validator.Result{
+			ok("db.sql", "b2"),
+			{Path: "flaky.bin", Status: validator.StatusError},
+			{Path: "static.iso", Checksum: "ee", Size: 10, Status: validator.StatusError,
+				Error: "checksum mismatch: storage recorded MD5 aa"},
+		}},
+		{Target: "/other", Started: y2022.Add(time.Hour), Results: []validator.Result{
+			ok("db.sql", "zz"),
+		}},
+		{Target: "/backup", Started: y2024, Results: []validator.Result{
+			ok("static.iso", "aa"), ok("db.sql", "b2"), ok("new.txt", "dd"),
+		}},
+	}
+	for _, run := range runs {
+		run.ID = NewRunID(run.Started)
+		if err := store.Save(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ages, err := Ages(ctx, store, func(s Summary) bool { return s.Target == "/backup" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileAge{
+		{Path: "static.iso", Checksum: "aa", Size: 10, UnchangedSince: y2020, SinceFirstRecord: true},
+		{Path: "db.sql", Checksum: "b2", Size: 10, UnchangedSince: y2022},
+		{Path: "new.txt", Checksum: "dd", Size: 10, UnchangedSince: y2024, SinceFirstRecord: true},
+	}
+	if len(ages) != len(want) {
+		t.Fatalf("got %d files, want %d: %+v", len(ages), len(want), ages)
+	}
+	for i := range want {
+		if ages[i] != want[i] {
+			t.Errorf("ages[%d] = %+v, want %+v", i, ages[i], want[i])
+		}
+	}
+
+	if got := ages[0].Age(y2024); got != y2024.Sub(y2020) {
+		t.Errorf("Age = %v", got)
+	}
+
+	// Runs of single files record their result as "."; different files
+	// must not be taken for one.
+	store, err = Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs = []*Run{
+		{Target: "/tmp/fa.txt", Started: y2020, Results: []validator.Result{ok(".", "a1")}},
+		{Target: "/tmp/fb.txt", Started: y2022, Results: []validator.Result{ok(".", "b1")}},
+		{Target: "/tmp/fa.txt", Started: y2024, Results: []validator.Result{ok(".", "a1")}},
+	}
+	for _, run := range runs {
+		run.ID = NewRunID(run.Started)
+		if err := store.Save(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ages, err = Ages(ctx, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []FileAge{{Path: "fa.txt", Checksum: "a1", Size: 10, UnchangedSince: y2020, SinceFirstRecord: true}}
+	if len(ages) != 1 || ages[0] != want[0] {
+		t.Errorf("single-file runs: got %+v, want %+v", ages, want)
+	}
+}
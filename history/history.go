@@ -0,0 +1,158 @@
+// Package history records validation runs. Stores are opened by DSN; the
+// scheme selects the backend, so small sites and a central collector can
+// use the same code with different durability needs:
+//
+//	store, err := history.Open("sqlite:///var/lib/backuptest/history.db")
+//
+// The JSONL backend is built in. The SQLite, PostgreSQL and S3 backends, in
+// packages backuptest/history/sqlite, backuptest/history/postgres and
+// backuptest/history/s3, register themselves with Register in the style of
+// database/sql drivers, so that their client libraries are only linked
+// into binaries that need them.
+package history
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backuptest/validator"
+)
+
+// ErrNotFound is returned when a run does not exist.
+var ErrNotFound = errors.New("run not found")
+
+// Run is one recorded validation run.
+type Run struct {
+	ID         string             `json:"id"`
+	Target     string             `json:"target"`
+	Started    time.Time          `json:"started"`
+	Finished   time.Time          `json:"finished"`
+	Provenance *Provenance        `json:"provenance,omitempty"`
+	Results    []validator.Result `json:"-"`
+}
+
+// Provenance records how a run was made, so that it can be repeated with
+// the same settings. Runs recorded by older versions have none.
+type Provenance struct {
+	// Version and Commit identify the backuptest build.
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	// Hostname is the machine the run was made on.
+	Hostname string `json:"hostname"`
+	// Args is the command line as given.
+	Args []string `json:"args"`
+	// Config is the effective value of every option, after defaults and
+	// environment variables were applied.
+	Config map[string]string `json:"config"`
+	// RerunOf is the ID of the run this run repeats, if any.
+	RerunOf string `json:"rerun_of,omitempty"`
+}
+
+// Summary describes a run without its results.
+type Summary struct {
+	ID         string                   `json:"id"`
+	Target     string                   `json:"target"`
+	Started    time.Time                `json:"started"`
+	Finished   time.Time                `json:"finished"`
+	Provenance *Provenance              `json:"provenance,omitempty"`
+	Counts     map[validator.Status]int `json:"counts"`
+}
+
+// Summarize returns the Summary of run.
+func (run *Run) Summarize() Summary {
+	s := Summary{
+		ID:         run.ID,
+		Target:     run.Target,
+		Started:    run.Started,
+		Finished:   run.Finished,
+		Provenance: run.Provenance,
+		Counts:     make(map[validator.Status]int),
+	}
+	for _, r := range run.Results {
+		s.Counts[r.Status]++
+	}
+	return s
+}
+
+// Store persists runs. Runs are immutable once saved.
+type Store interface {
+	// Save records run. It fails if a run with the same ID exists.
+	Save(ctx context.Context, run *Run) error
+	// Load returns the run with the given ID, or ErrNotFound.
+	Load(ctx context.Context, id string) (*Run, error)
+	// List returns summaries of all runs, oldest first.
+	List(ctx context.Context) ([]Summary, error)
+	Close() error
+}
+
+// OpenFunc opens a store from a DSN whose scheme it was registered for.
+type OpenFunc func(dsn string) (Store, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]OpenFunc)
+)
+
+// Register makes a backend available under scheme. It panics if scheme is
+// registered twice.
+func Register(scheme string, open OpenFunc) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, dup := backends[scheme]; dup {
+		panic("history: Register called twice for scheme " + scheme)
+	}
+	backends[scheme] = open
+}
+
+// Backends returns the registered schemes, sorted.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	schemes := make([]string, 0, len(backends))
+	for s := range backends {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open opens the store named by dsn. A DSN without a scheme is a path: an
+// existing directory is a JSONL store, and any other path is a SQLite
+// database, the default for new stores.
+func Open(dsn string) (Store, error) {
+	scheme := Scheme(dsn)
+
+	backendsMu.RLock()
+	open, ok := backends[scheme]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("history: no backend for %q (available: %s)", scheme, strings.Join(Backends(), ", "))
+	}
+	return open(dsn)
+}
+
+// Scheme returns the backend scheme Open uses for dsn.
+func Scheme(dsn string) string {
+	if i := strings.Index(dsn, "://"); i > 0 {
+		return dsn[:i]
+	}
+	if info, err := os.Stat(dsn); err == nil && info.IsDir() {
+		return "jsonl"
+	}
+	return "sqlite"
+}
+
+// NewRunID returns a run ID that sorts by start time.
+func NewRunID(started time.Time) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return started.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,189 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"backuptest/validator"
+)
+
+func init() {
+	Register("jsonl", openJSONL)
+}
+
+// jsonlStore keeps each run in its own append-only file, <id>.jsonl, in a
+// directory. The first line is the run's Summary; each following line is
+// one result. Files are written once and never modified.
+type jsonlStore struct {
+	dir string
+}
+
+func openJSONL(dsn string) (Store, error) {
+	dir := strings.TrimPrefix(dsn, "jsonl://")
+	if dir == "" {
+		return nil, errors.New("history: jsonl store needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	return &jsonlStore{dir: dir}, nil
+}
+
+func (s *jsonlStore) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("history: invalid run ID %q", id)
+	}
+	return filepath.Join(s.dir, id+".jsonl"), nil
+}
+
+func (s *jsonlStore) Save(ctx context.Context, run *Run) error {
+	final, err := s.path(run.ID)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".run-*")
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := WriteJSONL(ctx, tmp, run); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	// Linking rather than renaming refuses to replace an existing run.
+	if err := os.Link(tmp.Name(), final); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("history: run %s already exists", run.ID)
+		}
+		return fmt.Errorf("history: %w", err)
+	}
+	return nil
+}
+
+func (s *jsonlStore) Load(ctx context.Context, id string) (*Run, error) {
+	name, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	defer f.Close()
+
+	run, err := ReadJSONL(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("history: run %s: %w", id, err)
+	}
+	return run, nil
+}
+
+func (s *jsonlStore) List(ctx context.Context) ([]Summary, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+
+	var sums []Summary
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		sum, err := readSummary(name)
+		if err != nil {
+			return nil, err
+		}
+		sums = append(sums, sum)
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i].Started.Before(sums[j].Started) })
+	return sums, nil
+}
+
+func readSummary(name string) (Summary, error) {
+	var sum Summary
+	f, err := os.Open(name)
+	if err != nil {
+		return sum, fmt.Errorf("history: %w", err)
+	}
+	defer f.Close()
+
+	if sum, err = ReadJSONLSummary(f); err != nil {
+		return sum, fmt.Errorf("history: %s: %w", filepath.Base(name), err)
+	}
+	return sum, nil
+}
+
+func (s *jsonlStore) Close() error {
+	return nil
+}
+
+// WriteJSONL writes run in the format of the JSONL store: the run's Summary
+// on the first line, then one result per line. Other append-only backends
+// write the same format, so their objects can be copied into a JSONL
+// directory and read there.
+func WriteJSONL(ctx context.Context, w io.Writer, run *Run) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(run.Summarize()); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	for _, r := range run.Results {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	return nil
+}
+
+// ReadJSONL reads a run written by WriteJSONL.
+func ReadJSONL(ctx context.Context, r io.Reader) (*Run, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var sum Summary
+	if err := dec.Decode(&sum); err != nil {
+		return nil, err
+	}
+	run := &Run{ID: sum.ID, Target: sum.Target, Started: sum.Started, Finished: sum.Finished, Provenance: sum.Provenance}
+	for dec.More() {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var res validator.Result
+		if err := dec.Decode(&res); err != nil {
+			return nil, err
+		}
+		run.Results = append(run.Results, res)
+	}
+	return run, nil
+}
+
+// ReadJSONLSummary reads the Summary of a run written by WriteJSONL,
+// without reading its results.
+func ReadJSONLSummary(r io.Reader) (Summary, error) {
+	var sum Summary
+	err := json.NewDecoder(bufio.NewReader(r)).Decode(&sum)
+	return sum, err
+}
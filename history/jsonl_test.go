@@ -0,0 +1,38 @@
+package history_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"backuptest/history"
+	"backuptest/history/storetest"
+)
+
+func TestJSONLStore(t *testing.T) {
+	store, err := history.Open("jsonl://" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	storetest.TestStore(t, store)
+}
+
+func TestOpenUnknownBackend(t *testing.T) {
+	if _, err := history.Open("mongodb://collector/backuptest"); err == nil {
+		t.Error("expected an error for an unregistered backend")
+	}
+}
+
+func TestOpenPlainPath(t *testing.T) {
+	dir := t.TempDir()
+	store, err := history.Open(dir)
+	if err != nil {
+		t.Fatalf("existing directory: %v", err)
+	}
+	store.Close()
+
+	// New stores default to SQLite, which this test binary does not link.
+	if _, err := history.Open(filepath.Join(dir, "history.db")); err == nil {
+		t.Error("expected a new path to need the sqlite backend")
+	}
+}
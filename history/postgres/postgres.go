@@ -0,0 +1,171 @@
+// Package postgres provides a PostgreSQL history store, for a central
+// collector that many hosts record their runs to. Importing it registers
+// the "postgres" scheme with package history:
+//
+//	import _ "backuptest/history/postgres"
+//
+//	store, err := history.Open("postgres://backuptest@collector/backuptest?sslmode=verify-full")
+//
+// The DSN is passed to github.com/lib/pq as it is, so any of its connection
+// parameters can be given.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"backuptest/history"
+	"backuptest/validator"
+
+	_ "github.com/lib/pq"
+)
+
+func init() {
+	history.Register("postgres", open)
+}
+
+// Runs and results are stored as JSON, as in the SQLite store, so that
+// fields added to them need no schema change; the columns beside them are
+// what queries select and sort on.
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id      TEXT PRIMARY KEY,
+	started TIMESTAMPTZ NOT NULL,
+	summary JSONB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS results (
+	run_id TEXT NOT NULL REFERENCES runs(id),
+	seq    INTEGER NOT NULL,
+	result JSONB NOT NULL,
+	PRIMARY KEY (run_id, seq)
+);`
+
+type store struct {
+	db *sql.DB
+}
+
+func open(dsn string) (history.Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	return &store{db: db}, nil
+}
+
+func (s *store) Save(ctx context.Context, run *history.Run) error {
+	summary, err := json.Marshal(run.Summarize())
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Hosts save concurrently, so a duplicate is found by the insert itself
+	// rather than by looking first.
+	res, err := tx.ExecContext(ctx, `INSERT INTO runs (id, started, summary) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO NOTHING`, run.ID, run.Started, summary)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("history: %w", err)
+	} else if n == 0 {
+		return fmt.Errorf("history: run %s already exists", run.ID)
+	}
+
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO results (run_id, seq, result) VALUES ($1, $2, $3)`)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	defer insert.Close()
+	for i, r := range run.Results {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+		if _, err := insert.ExecContext(ctx, run.ID, i, data); err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	return nil
+}
+
+func (s *store) Load(ctx context.Context, id string) (*history.Run, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT summary FROM runs WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, history.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	var sum history.Summary
+	if err := json.Unmarshal(data, &sum); err != nil {
+		return nil, fmt.Errorf("history: run %s: %w", id, err)
+	}
+	run := &history.Run{ID: sum.ID, Target: sum.Target, Started: sum.Started, Finished: sum.Finished, Provenance: sum.Provenance}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT result FROM results WHERE run_id = $1 ORDER BY seq`, id)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r validator.Result
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("history: run %s: %w", id, err)
+		}
+		run.Results = append(run.Results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	return run, nil
+}
+
+func (s *store) List(ctx context.Context) ([]history.Summary, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT summary FROM runs ORDER BY started`)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	defer rows.Close()
+
+	var sums []history.Summary
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		var sum history.Summary
+		if err := json.Unmarshal(data, &sum); err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		sums = append(sums, sum)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	return sums, nil
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"backuptest/history"
+	"backuptest/history/storetest"
+)
+
+// TestStore runs against the server named by BACKUPTEST_POSTGRES_DSN, a
+// postgres:// URL, in a schema of its own that is dropped afterwards.
+func TestStore(t *testing.T) {
+	dsn := os.Getenv("BACKUPTEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("BACKUPTEST_POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	schema := fmt.Sprintf("backuptest_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP SCHEMA " + schema + " CASCADE")
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	store, err := history.Open(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	storetest.TestStore(t, store)
+}
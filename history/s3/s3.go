@@ -0,0 +1,189 @@
+// Package s3 provides an append-only history store in an S3 bucket.
+// Importing it registers the "s3" scheme with package history:
+//
+//	import _ "backuptest/history/s3"
+//
+//	store, err := history.Open("s3://backup-audit/backuptest?region=eu-west-1")
+//
+// Each run is one object, <prefix>/<run-id>.jsonl, in the format of the
+// JSONL store. Objects are written with If-None-Match so that a run is
+// never replaced; with S3 Object Lock on the bucket, they cannot be
+// deleted either. Credentials come from the usual AWS sources (environment,
+// shared config, instance role). The endpoint query parameter selects
+// S3-compatible storage such as MinIO or Ceph, addressed by path:
+//
+//	s3://backuptest/history?endpoint=https://minio.example.com:9000
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"backuptest/history"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+func init() {
+	history.Register("s3", open)
+}
+
+type store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func open(dsn string) (history.Store, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	if u.Host == "" {
+		return nil, errors.New("history: s3 store needs a bucket")
+	}
+	q := u.Query()
+	endpoint := q.Get("endpoint")
+
+	var opts []func(*config.LoadOptions) error
+	if region := q.Get("region"); region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	if cfg.Region == "" {
+		if endpoint == "" {
+			return nil, errors.New("history: s3 store needs a region (AWS_REGION or ?region=)")
+		}
+		// S3-compatible storage usually ignores the region, but requests
+		// must still be signed for one.
+		cfg.Region = "us-east-1"
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &store{client: client, bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+func (s *store) key(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("history: invalid run ID %q", id)
+	}
+	return path.Join(s.prefix, id+".jsonl"), nil
+}
+
+func (s *store) Save(ctx context.Context, run *history.Run) error {
+	key, err := s.key(run.ID)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := history.WriteJSONL(ctx, &buf, run); err != nil {
+		return err
+	}
+
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/jsonl"),
+		IfNoneMatch: aws.String("*"),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+		return fmt.Errorf("history: run %s already exists", run.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	return nil
+}
+
+func (s *store) Load(ctx context.Context, id string) (*history.Run, error) {
+	key, err := s.key(id)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	var noKey *types.NoSuchKey
+	if errors.As(err, &noKey) {
+		return nil, history.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	defer out.Body.Close()
+
+	run, err := history.ReadJSONL(ctx, out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("history: run %s: %w", id, err)
+	}
+	return run, nil
+}
+
+func (s *store) List(ctx context.Context) ([]history.Summary, error) {
+	prefix := s.prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var sums []history.Summary
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if !strings.HasSuffix(key, ".jsonl") {
+				continue
+			}
+			sum, err := s.readSummary(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			sums = append(sums, sum)
+		}
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i].Started.Before(sums[j].Started) })
+	return sums, nil
+}
+
+func (s *store) readSummary(ctx context.Context, key string) (history.Summary, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return history.Summary{}, fmt.Errorf("history: %w", err)
+	}
+	defer out.Body.Close()
+
+	sum, err := history.ReadJSONLSummary(out.Body)
+	if err != nil {
+		return sum, fmt.Errorf("history: %s: %w", path.Base(key), err)
+	}
+	return sum, nil
+}
+
+func (s *store) Close() error {
+	return nil
+}
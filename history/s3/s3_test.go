@@ -0,0 +1,134 @@
+package s3
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"backuptest/history"
+	"backuptest/history/storetest"
+)
+
+// fakeS3 serves the part of the S3 API the store uses, with path-style
+// addressing, from memory.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte // "bucket/key"
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodPut:
+		name := bucket + "/" + key
+		if _, ok := f.objects[name]; ok && r.Header.Get("If-None-Match") == "*" {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[name] = data
+
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		prefix, delim := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+		type object struct{ Key string }
+		result := struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			Prefix      string
+			IsTruncated bool
+			Contents    []object
+		}{Name: bucket, Prefix: prefix}
+		for name := range f.objects {
+			b, k, _ := strings.Cut(name, "/")
+			rest, ok := strings.CutPrefix(k, prefix)
+			if b != bucket || !ok || (delim != "" && strings.Contains(rest, delim)) {
+				continue
+			}
+			result.Contents = append(result.Contents, object{k})
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[bucket+"/"+key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Write(data)
+
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+code+"</Message></Error>")
+}
+
+func setCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+func TestStore(t *testing.T) {
+	setCredentials(t)
+	fake := &fakeS3{objects: map[string][]byte{
+		// Objects outside the store's prefix are not runs of it.
+		"audit/other.jsonl":               []byte("not a run\n"),
+		"audit/history/archive/old.jsonl": []byte("not a run\n"),
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	store, err := history.Open("s3://audit/history?endpoint=" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	storetest.TestStore(t, store)
+
+	var runs int
+	for name := range fake.objects {
+		if rest, ok := strings.CutPrefix(name, "audit/history/2026"); ok && strings.HasSuffix(rest, ".jsonl") {
+			runs++
+		}
+	}
+	if runs != 2 {
+		t.Errorf("got %d run objects, want 2: %v", runs, fake.objects)
+	}
+}
+
+func TestOpenNeedsRegion(t *testing.T) {
+	setCredentials(t)
+	if _, err := history.Open("s3://audit/history"); err == nil || !strings.Contains(err.Error(), "region") {
+		t.Errorf("Open without a region: got %v, want region error", err)
+	}
+	if _, err := history.Open("s3:///history?region=eu-west-1"); err == nil {
+		t.Error("Open without a bucket: expected an error")
+	}
+}
@@ -0,0 +1,90 @@
+package history
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"backuptest/validator"
+)
+
+// Match is one recorded entry that matched a search.
+type Match struct {
+	// Path is the file's path within the run's target, or the target's
+	// name if the run validated a single file.
+	Path string
+	// Member is the archive member name, if the match is inside an archive.
+	Member string
+}
+
+// Search looks for pattern among the file paths and archive members of
+// every recorded run, newest run first, and calls fn for each run with at
+// least one match. A pattern containing glob characters (*?[) is matched
+// with path.Match against whole names and against every trailing part of
+// a name that starts after a slash; any other pattern matches as a
+// substring.
+func Search(ctx context.Context, store Store, pattern string, fn func(Summary, []Match) error) error {
+	match := newMatcher(pattern)
+
+	runs, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		run, err := store.Load(ctx, runs[i].ID)
+		if err != nil {
+			return err
+		}
+
+		var matches []Match
+		for _, r := range run.Results {
+			name := resultPath(run.Target, r)
+			if match(name) {
+				matches = append(matches, Match{Path: name})
+			}
+			for _, m := range r.Members {
+				if match(m) {
+					matches = append(matches, Match{Path: name, Member: m})
+				}
+			}
+		}
+		if len(matches) > 0 {
+			if err := fn(runs[i], matches); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resultPath returns the path of r within target. A run of a single file
+// records its result as ".", the target itself, which is named by the
+// target's base name instead.
+func resultPath(target string, r validator.Result) string {
+	if r.Path == "." {
+		return filepath.Base(target)
+	}
+	return r.Path
+}
+
+func newMatcher(pattern string) func(string) bool {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return func(name string) bool {
+			return strings.Contains(name, pattern)
+		}
+	}
+	return func(name string) bool {
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "./"), "/")
+		for {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			i := strings.IndexByte(name, '/')
+			if i < 0 {
+				return false
+			}
+			name = name[i+1:]
+		}
+	}
+}
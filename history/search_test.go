@@ -0,0 +1,76 @@
+package history
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"backuptest/validator"
+)
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2026, 10, 1, 2, 0, 0, 0, time.UTC)
+	runs := []*Run{
+		{Target: "/backup/2026-10-01", Started: day, Results: []validator.Result{
+			{Path: "files.tar.gz", Members: []string{"./invoices/2023/Q4/a.pdf", "./invoices/2024/Q1/b.pdf"}},
+		}},
+		{Target: "/backup/2026-10-02", Started: day.Add(24 * time.Hour), Results: []validator.Result{
+			{Path: "invoices/2024/Q1/b.pdf"},
+		}},
+		// A single archive validated on its own.
+		{Target: "/backup/weekly.tar", Started: day.Add(48 * time.Hour), Results: []validator.Result{
+			{Path: ".", Members: []string{"invoices/2023/a.txt"}},
+		}},
+	}
+	for _, run := range runs {
+		run.ID = NewRunID(run.Started)
+		if err := store.Save(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(pattern string) map[string][]Match {
+		found := make(map[string][]Match)
+		err := Search(ctx, store, pattern, func(run Summary, matches []Match) error {
+			found[run.Target] = matches
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+
+	got := search("invoices/2023/Q4/")
+	want := map[string][]Match{"/backup/2026-10-01": {{Path: "files.tar.gz", Member: "./invoices/2023/Q4/a.pdf"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("substring search = %v, want %v", got, want)
+	}
+
+	got = search("weekly.tar")
+	want = map[string][]Match{"/backup/weekly.tar": {{Path: "weekly.tar"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("single-file run search = %v, want %v", got, want)
+	}
+	got = search("invoices/2023/a")
+	want = map[string][]Match{"/backup/weekly.tar": {{Path: "weekly.tar", Member: "invoices/2023/a.txt"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("single-file run member search = %v, want %v", got, want)
+	}
+
+	got = search("2024/*/*.pdf")
+	want = map[string][]Match{
+		"/backup/2026-10-01": {{Path: "files.tar.gz", Member: "./invoices/2024/Q1/b.pdf"}},
+		"/backup/2026-10-02": {{Path: "invoices/2024/Q1/b.pdf"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("glob search = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,192 @@
+// Package sqlite provides a SQLite history store, the default for new
+// stores. Importing it registers the "sqlite" scheme with package history:
+//
+//	import _ "backuptest/history/sqlite"
+//
+//	store, err := history.Open("sqlite:///var/lib/backuptest/history.db")
+//
+// With ?mode=ro, the database is opened read-only: it must exist, and is
+// neither created nor given a schema. Save then fails. It uses cgo.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"backuptest/history"
+	"backuptest/validator"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	history.Register("sqlite", open)
+}
+
+// Runs and results are stored as JSON, so that fields added to them need no
+// schema change; the columns beside them are what queries select and sort on.
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id      TEXT PRIMARY KEY,
+	started INTEGER NOT NULL,
+	summary TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS results (
+	run_id TEXT NOT NULL REFERENCES runs(id),
+	seq    INTEGER NOT NULL,
+	result TEXT NOT NULL,
+	PRIMARY KEY (run_id, seq)
+);`
+
+type store struct {
+	db *sql.DB
+}
+
+func open(dsn string) (history.Store, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(dsn, "sqlite://"), "?")
+	if path == "" {
+		return nil, errors.New("history: sqlite store needs a database path")
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	readOnly := params.Get("mode") == "ro"
+
+	// Concurrent runs wait for each other's writes instead of failing.
+	conn := "file:" + path + "?_busy_timeout=10000&_foreign_keys=1"
+	if readOnly {
+		conn += "&mode=ro"
+	}
+	db, err := sql.Open("sqlite3", conn)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	if readOnly {
+		// sql.Open connects lazily; fail here, as a writable open does.
+		err = db.Ping()
+	} else {
+		_, err = db.Exec(schema)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("history: %s: %w", path, err)
+	}
+	return &store{db: db}, nil
+}
+
+func (s *store) Save(ctx context.Context, run *history.Run) error {
+	summary, err := json.Marshal(run.Summarize())
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM runs WHERE id = ?`, run.ID).Scan(&exists)
+	if err == nil {
+		return fmt.Errorf("history: run %s already exists", run.ID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO runs (id, started, summary) VALUES (?, ?, ?)`,
+		run.ID, run.Started.UnixNano(), summary); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO results (run_id, seq, result) VALUES (?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	defer insert.Close()
+	for i, r := range run.Results {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+		if _, err := insert.ExecContext(ctx, run.ID, i, data); err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	return nil
+}
+
+func (s *store) Load(ctx context.Context, id string) (*history.Run, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT summary FROM runs WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, history.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	var sum history.Summary
+	if err := json.Unmarshal(data, &sum); err != nil {
+		return nil, fmt.Errorf("history: run %s: %w", id, err)
+	}
+	run := &history.Run{ID: sum.ID, Target: sum.Target, Started: sum.Started, Finished: sum.Finished, Provenance: sum.Provenance}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT result FROM results WHERE run_id = ? ORDER BY seq`, id)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r validator.Result
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("history: run %s: %w", id, err)
+		}
+		run.Results = append(run.Results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	return run, nil
+}
+
+func (s *store) List(ctx context.Context) ([]history.Summary, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT summary FROM runs ORDER BY started`)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	defer rows.Close()
+
+	var sums []history.Summary
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		var sum history.Summary
+		if err := json.Unmarshal(data, &sum); err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		sums = append(sums, sum)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	return sums, nil
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,75 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"backuptest/history"
+	"backuptest/history/storetest"
+)
+
+func TestStore(t *testing.T) {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	storetest.TestStore(t, store)
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	missing := filepath.Join(dir, "missing.db")
+	if _, err := history.Open("sqlite://" + missing + "?mode=ro"); err == nil {
+		t.Error("read-only open of a missing database: expected an error")
+	}
+	if _, err := os.Stat(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("read-only open created %s: %v", missing, err)
+	}
+
+	// An empty file is not given a schema.
+	empty := filepath.Join(dir, "empty.db")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if store, err := history.Open("sqlite://" + empty + "?mode=ro"); err == nil {
+		if _, err := store.List(ctx); err == nil {
+			t.Error("List of an empty database: expected an error")
+		}
+		store.Close()
+	}
+	if info, err := os.Stat(empty); err != nil || info.Size() != 0 {
+		t.Errorf("read-only open wrote to an empty file: %v, %v", info, err)
+	}
+
+	path := filepath.Join(dir, "history.db")
+	store, err := history.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &history.Run{ID: history.NewRunID(time.Now()), Target: "/backup", Started: time.Now()}
+	if err := store.Save(ctx, run); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = history.Open("sqlite://" + path + "?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if runs, err := store.List(ctx); err != nil || len(runs) != 1 {
+		t.Errorf("List = %v, %v; want the saved run", runs, err)
+	}
+	run.ID = history.NewRunID(time.Now().Add(time.Second))
+	if err := store.Save(ctx, run); err == nil {
+		t.Error("Save to a read-only store: expected an error")
+	}
+}
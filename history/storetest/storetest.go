@@ -0,0 +1,76 @@
+// Package storetest implements tests for history.Store backends, in the
+// style of testing/fstest.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"backuptest/history"
+	"backuptest/validator"
+)
+
+// TestStore checks that store, which must be empty, saves, loads and lists
+// runs as history.Store requires.
+func TestStore(t *testing.T, store history.Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	started := time.Date(2026, 10, 1, 2, 0, 0, 0, time.UTC)
+	older := &history.Run{
+		ID:      history.NewRunID(started.Add(-24 * time.Hour)),
+		Target:  "/backup",
+		Started: started.Add(-24 * time.Hour),
+	}
+	run := &history.Run{
+		ID:       history.NewRunID(started),
+		Target:   "/backup",
+		Started:  started,
+		Finished: started.Add(time.Minute),
+		Provenance: &history.Provenance{
+			Version:  "v1.4.0",
+			Commit:   "3f2a9c1",
+			Hostname: "backup01",
+			Args:     []string{"-hexdump", "/backup"},
+			Config:   map[string]string{"hexdump": "true", "recheck": "true"},
+		},
+		Results: []validator.Result{
+			{Path: "db.sql", Size: 4, Checksum: "8d777f385d3dfec8815d20f7496026dc", Status: validator.StatusOK},
+			{Path: "logs/app.log", Status: validator.StatusError, Error: "input/output error",
+				ReadFailure: &validator.ReadFailure{Offset: 4096, Errno: 5}},
+		},
+	}
+	for _, r := range []*history.Run{run, older} {
+		if err := store.Save(ctx, r); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	if err := store.Save(ctx, run); err == nil {
+		t.Error("saving a run twice should fail")
+	}
+
+	got, err := store.Load(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(got, run) {
+		t.Errorf("Load = %+v, want %+v", got, run)
+	}
+	if got, err := store.Load(ctx, older.ID); err != nil || len(got.Results) != 0 || got.Provenance != nil {
+		t.Errorf("Load(older) = %+v, %v; want a run without results or provenance", got, err)
+	}
+	if _, err := store.Load(ctx, "missing"); !errors.Is(err, history.ErrNotFound) {
+		t.Errorf("Load(missing) = %v, want ErrNotFound", err)
+	}
+
+	runs, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(runs) != 2 || runs[0].ID != older.ID || runs[1].Counts[validator.StatusError] != 1 {
+		t.Errorf("List = %+v", runs)
+	}
+}
@@ -0,0 +1,102 @@
+package validator
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// sectorSize is the unit kernel block-layer errors are logged in.
+const sectorSize = 512
+
+// BadBlockReport maps the failing regions of a corrupt file to sectors on
+// the underlying block device, for handing to the storage vendor.
+type BadBlockReport struct {
+	// Device is the block device holding the file system, e.g. "sda1".
+	Device string `json:"device,omitempty"`
+	// Disk is the whole disk the device lives on, e.g. "sda". Kernel
+	// I/O errors are logged against it.
+	Disk string `json:"disk,omitempty"`
+	// StartSector is where Device starts on Disk.
+	StartSector int64       `json:"start_sector,omitempty"`
+	Regions     []BadRegion `json:"regions,omitempty"`
+	// Note explains why mapping was incomplete, if it was.
+	Note string `json:"note,omitempty"`
+}
+
+// BadRegion is one failing location in a file.
+type BadRegion struct {
+	FileOffset int64 `json:"file_offset"`
+	// DiskSector is the matching sector on Disk, or -1 if the offset is not
+	// backed by a mapped extent (e.g. a hole or inline data).
+	DiskSector int64 `json:"disk_sector"`
+	// KernelErrors counts kernel log I/O errors reported for DiskSector.
+	KernelErrors int `json:"kernel_errors,omitempty"`
+}
+
+// fileExtent is one FIEMAP extent, in bytes.
+type fileExtent struct {
+	Logical  uint64
+	Physical uint64
+	Length   uint64
+}
+
+// kernelIOError matches block-layer errors such as
+// "I/O error, dev sda, sector 1052672 op 0x0:(READ) ..." and
+// "critical medium error, dev sdb, sector 8192".
+var kernelIOError = regexp.MustCompile(`error, dev ([\w-]+), sector (\d+)`)
+
+// parseKernelIOErrors counts logged I/O errors per sector for disk.
+func parseKernelIOErrors(lines []string, disk string) map[int64]int {
+	errs := make(map[int64]int)
+	for _, line := range lines {
+		m := kernelIOError.FindStringSubmatch(line)
+		if m == nil || m[1] != disk {
+			continue
+		}
+		sector, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		errs[sector]++
+	}
+	return errs
+}
+
+// buildBadRegions maps the failing file offset to a disk sector and adds
+// every kernel-logged error sector that falls inside the file's extents.
+func buildBadRegions(extents []fileExtent, startSector, failOffset int64, logged map[int64]int) []BadRegion {
+	regions := make(map[int64]*BadRegion)
+
+	fail := &BadRegion{FileOffset: failOffset, DiskSector: -1}
+	for _, e := range extents {
+		if uint64(failOffset) >= e.Logical && uint64(failOffset) < e.Logical+e.Length {
+			fail.DiskSector = startSector + int64(e.Physical+uint64(failOffset)-e.Logical)/sectorSize
+			fail.KernelErrors = logged[fail.DiskSector]
+			break
+		}
+	}
+	regions[fail.DiskSector] = fail
+
+	for sector, count := range logged {
+		for _, e := range extents {
+			first := startSector + int64(e.Physical/sectorSize)
+			last := first + int64(e.Length/sectorSize)
+			if sector < first || sector >= last {
+				continue
+			}
+			if _, ok := regions[sector]; !ok {
+				offset := int64(e.Logical) + (sector-first)*sectorSize
+				regions[sector] = &BadRegion{FileOffset: offset, DiskSector: sector, KernelErrors: count}
+			}
+			break
+		}
+	}
+
+	out := make([]BadRegion, 0, len(regions))
+	for _, r := range regions {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FileOffset < out[j].FileOffset })
+	return out
+}
@@ -0,0 +1,208 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fsIocFiemap      = 0xC020660B // _IOWR('f', 11, struct fiemap)
+	fiemapFlagSync   = 0x1
+	fiemapExtentLast = 0x1
+	fiemapBatch      = 128
+
+	// Extents with these flags have no reliable physical location.
+	fiemapExtentUnreliable = 0x2 | 0x4 | 0x8 | 0x100 | 0x200 // UNKNOWN, DELALLOC, ENCODED, NOT_ALIGNED, DATA_INLINE
+)
+
+type fiemapExtentRaw struct {
+	Logical  uint64
+	Physical uint64
+	Length   uint64
+	_        [2]uint64
+	Flags    uint32
+	_        [3]uint32
+}
+
+type fiemapRequest struct {
+	Start         uint64
+	Length        uint64
+	Flags         uint32
+	MappedExtents uint32
+	ExtentCount   uint32
+	_             uint32
+	Extents       [fiemapBatch]fiemapExtentRaw
+}
+
+// mapBadBlocks maps a file's failing offset to device sectors using FIEMAP
+// and cross-references the kernel log for I/O errors inside the file.
+func mapBadBlocks(file *os.File, failOffset int64) (*BadBlockReport, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, errors.New("bad-block mapping: cannot determine device")
+	}
+
+	report := &BadBlockReport{}
+	if err := resolveDevice(report, uint64(st.Dev)); err != nil {
+		return nil, err
+	}
+
+	extents, unreliable, err := fiemap(file)
+	if err != nil {
+		return nil, fmt.Errorf("bad-block mapping: FIEMAP: %w", err)
+	}
+	var notes []string
+	if unreliable {
+		notes = append(notes, "some extents have no reliable physical location")
+	}
+	if strings.HasPrefix(report.Device, "dm-") || strings.HasPrefix(report.Device, "md") {
+		notes = append(notes, "device is virtual (device-mapper/RAID); sectors are relative to "+report.Device)
+	}
+
+	lines, err := readKernelLog()
+	if err != nil {
+		notes = append(notes, "kernel log not read: "+err.Error())
+	}
+	report.Regions = buildBadRegions(extents, report.StartSector, failOffset, parseKernelIOErrors(lines, report.Disk))
+	report.Note = strings.Join(notes, "; ")
+	return report, nil
+}
+
+// resolveDevice fills in the device, disk and partition start from sysfs.
+func resolveDevice(report *BadBlockReport, dev uint64) error {
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	sysPath := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+
+	target, err := filepath.EvalSymlinks(sysPath)
+	if err != nil {
+		return fmt.Errorf("bad-block mapping: device %d:%d not found in sysfs", major, minor)
+	}
+	report.Device = filepath.Base(target)
+	report.Disk = report.Device
+
+	if _, err := os.Stat(filepath.Join(target, "partition")); err == nil {
+		report.Disk = filepath.Base(filepath.Dir(target))
+		data, err := os.ReadFile(filepath.Join(target, "start"))
+		if err != nil {
+			return fmt.Errorf("bad-block mapping: %w", err)
+		}
+		report.StartSector, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad-block mapping: partition start: %w", err)
+		}
+	}
+	return nil
+}
+
+// fiemap returns every extent of file, and whether any extent had to be
+// skipped because its physical location is unreliable.
+func fiemap(file *os.File) ([]fileExtent, bool, error) {
+	var extents []fileExtent
+	unreliable := false
+	req := &fiemapRequest{}
+
+	for start := uint64(0); ; {
+		*req = fiemapRequest{
+			Start:       start,
+			Length:      ^uint64(0),
+			Flags:       fiemapFlagSync,
+			ExtentCount: fiemapBatch,
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(req)))
+		if errno != 0 {
+			return nil, false, errno
+		}
+		if req.MappedExtents == 0 {
+			return extents, unreliable, nil
+		}
+
+		for _, e := range req.Extents[:req.MappedExtents] {
+			if e.Flags&fiemapExtentUnreliable != 0 {
+				unreliable = true
+			} else {
+				extents = append(extents, fileExtent{e.Logical, e.Physical, e.Length})
+			}
+			if e.Flags&fiemapExtentLast != 0 {
+				return extents, unreliable, nil
+			}
+			start = e.Logical + e.Length
+		}
+	}
+}
+
+// CheckBadBlocks reports why bad-block mapping would not work for files
+// under dir, or nil if it would: dir must be on a local block device and
+// the kernel log must be readable.
+func CheckBadBlocks(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("bad-block mapping: cannot determine device")
+	}
+	if err := resolveDevice(&BadBlockReport{}, uint64(st.Dev)); err != nil {
+		return err
+	}
+
+	fd, err := openKernelLog()
+	if err != nil {
+		return err
+	}
+	return syscall.Close(fd)
+}
+
+// openKernelLog opens /dev/kmsg for non-blocking reads. It is opened with
+// syscall directly: os.File.Fd would switch the descriptor back to
+// blocking mode and the last read would never return.
+func openKernelLog() (int, error) {
+	fd, err := syscall.Open("/dev/kmsg", syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: "/dev/kmsg", Err: err}
+	}
+	return fd, nil
+}
+
+// readKernelLog returns the messages currently in the kernel ring buffer.
+func readKernelLog() ([]string, error) {
+	fd, err := openKernelLog()
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	var lines []string
+	buf := make([]byte, 8192)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EPIPE {
+			// Records were overwritten while reading; keep going.
+			continue
+		}
+		if err != nil || n <= 0 {
+			break
+		}
+		// Records look like "prio,seq,usec,flags;message".
+		record := string(buf[:n])
+		if i := strings.IndexByte(record, ';'); i >= 0 {
+			record = record[i+1:]
+		}
+		if i := strings.IndexByte(record, '\n'); i >= 0 {
+			record = record[:i]
+		}
+		lines = append(lines, record)
+	}
+	return lines, nil
+}
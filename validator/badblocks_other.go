@@ -0,0 +1,18 @@
+//go:build !linux
+
+package validator
+
+import (
+	"errors"
+	"os"
+)
+
+// CheckBadBlocks reports why bad-block mapping would not work for files
+// under dir. It always fails on this platform.
+func CheckBadBlocks(dir string) error {
+	return errors.New("bad-block mapping is only supported on Linux")
+}
+
+func mapBadBlocks(file *os.File, failOffset int64) (*BadBlockReport, error) {
+	return nil, errors.New("bad-block mapping is only supported on Linux")
+}
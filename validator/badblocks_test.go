@@ -0,0 +1,40 @@
+package validator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildBadRegions(t *testing.T) {
+	log := []string{
+		"blk_update_request: I/O error, dev sda, sector 10248 op 0x0:(READ) flags 0x80700 phys_seg 1 prio class 0",
+		"I/O error, dev sda, sector 10248 op 0x0:(READ) flags 0x0 phys_seg 1 prio class 0",
+		"critical medium error, dev sda, sector 20016 op 0x0:(READ) flags 0x0 phys_seg 1 prio class 0",
+		"I/O error, dev sdb, sector 10248 op 0x0:(READ) flags 0x0 phys_seg 1 prio class 0",
+		"I/O error, dev sda, sector 999999 op 0x0:(READ) flags 0x0 phys_seg 1 prio class 0",
+		"EXT4-fs (sda1): mounted filesystem with ordered data mode",
+	}
+	logged := parseKernelIOErrors(log, "sda")
+	if want := map[int64]int{10248: 2, 20016: 1, 999999: 1}; !reflect.DeepEqual(logged, want) {
+		t.Fatalf("parseKernelIOErrors = %v, want %v", logged, want)
+	}
+
+	// Partition starts at sector 2048; the file has two 4 KiB-block extents.
+	extents := []fileExtent{
+		{Logical: 0, Physical: 8192 * sectorSize, Length: 8192},
+		{Logical: 8192, Physical: 17960 * sectorSize, Length: 8192},
+	}
+	got := buildBadRegions(extents, 2048, 4096, logged)
+	want := []BadRegion{
+		{FileOffset: 4096, DiskSector: 10248, KernelErrors: 2},
+		{FileOffset: 8192 + 8*sectorSize, DiskSector: 20016, KernelErrors: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildBadRegions = %+v, want %+v", got, want)
+	}
+
+	got = buildBadRegions(extents, 2048, 1<<20, nil)
+	if len(got) != 1 || got[0].DiskSector != -1 {
+		t.Errorf("offset outside extents: got %+v, want one unmapped region", got)
+	}
+}
@@ -0,0 +1,268 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Encryption describes the keys an encrypted backup file can be decrypted
+// with, as recorded in its OpenPGP packets or age header.
+type Encryption struct {
+	// Format is "OpenPGP" or "age".
+	Format string `json:"format"`
+	// Keys identifies the recipient keys: OpenPGP key IDs or fingerprints
+	// in upper-case hex, and "<type>:<tag>" for age recipients that carry
+	// a key tag (ssh-ed25519, ssh-rsa, piv-p256).
+	Keys []string `json:"keys,omitempty"`
+	// Anonymous counts recipients that cannot be identified from the file,
+	// such as OpenPGP wildcard key IDs and age X25519 recipients.
+	Anonymous int `json:"anonymous,omitempty"`
+	// Passphrase reports that the file can be decrypted with a passphrase.
+	Passphrase bool `json:"passphrase,omitempty"`
+	// Retired lists the entries of Keys that were given to WithRetiredKeys.
+	Retired []string `json:"retired,omitempty"`
+}
+
+// encryptionHeaderSize bounds how much of a file is read to find its
+// recipients.
+const encryptionHeaderSize = 64 << 10
+
+// OpenPGP packet tags (RFC 9580, section 5).
+const (
+	pgpTagPKESK = 1  // public-key encrypted session key
+	pgpTagSKESK = 3  // symmetric-key encrypted session key
+	pgpTagSED   = 9  // symmetrically encrypted data
+	pgpTagSEIPD = 18 // symmetrically encrypted and integrity protected data
+	pgpTagAEAD  = 20 // AEAD encrypted data (LibrePGP)
+)
+
+// inspectEncryption identifies OpenPGP and age encrypted files, binary or
+// ASCII-armored, and lists their recipients. It returns nil for any other
+// file.
+func inspectEncryption(r io.ReaderAt, size int64) *Encryption {
+	buf := make([]byte, min(size, encryptionHeaderSize))
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil
+	}
+	buf = buf[:n]
+
+	switch {
+	case bytes.HasPrefix(buf, []byte("age-encryption.org/v1\n")):
+		return parseAgeHeader(buf)
+	case bytes.HasPrefix(buf, []byte("-----BEGIN AGE ENCRYPTED FILE-----")):
+		if hdr := dearmor(buf); bytes.HasPrefix(hdr, []byte("age-encryption.org/v1\n")) {
+			return parseAgeHeader(hdr)
+		}
+		return nil
+	case bytes.HasPrefix(buf, []byte("-----BEGIN PGP MESSAGE-----")):
+		return parseOpenPGP(dearmor(buf))
+	}
+	return parseOpenPGP(buf)
+}
+
+// parseAgeHeader lists the recipient stanzas of an age header.
+func parseAgeHeader(hdr []byte) *Encryption {
+	e := &Encryption{Format: "age"}
+	for _, line := range strings.Split(string(hdr), "\n")[1:] {
+		if strings.HasPrefix(line, "---") {
+			break
+		}
+		if !strings.HasPrefix(line, "-> ") {
+			continue
+		}
+		args := strings.Fields(line[3:])
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "scrypt":
+			e.Passphrase = true
+		case "ssh-ed25519", "ssh-rsa", "piv-p256":
+			if len(args) > 1 {
+				e.Keys = append(e.Keys, args[0]+":"+args[1])
+				break
+			}
+			e.Anonymous++
+		default:
+			e.Anonymous++
+		}
+	}
+	return e
+}
+
+// parseOpenPGP reads the session key packets at the start of an OpenPGP
+// message. It returns nil unless they are followed by encrypted data.
+func parseOpenPGP(buf []byte) *Encryption {
+	e := &Encryption{Format: "OpenPGP"}
+	for {
+		tag, hdrLen, bodyLen, ok := openPGPHeader(buf)
+		if !ok {
+			return nil
+		}
+		switch tag {
+		case pgpTagSED, pgpTagSEIPD, pgpTagAEAD:
+			if len(e.Keys) == 0 && e.Anonymous == 0 && !e.Passphrase {
+				return nil
+			}
+			return e
+		}
+		if bodyLen < 0 || bodyLen > int64(len(buf)-hdrLen) {
+			return nil
+		}
+		body := buf[hdrLen : hdrLen+int(bodyLen)]
+		buf = buf[hdrLen+int(bodyLen):]
+
+		switch tag {
+		case pgpTagPKESK:
+			id, ok := pkeskKeyID(body)
+			if !ok {
+				return nil
+			}
+			if id == "" {
+				e.Anonymous++
+			} else {
+				e.Keys = append(e.Keys, id)
+			}
+		case pgpTagSKESK:
+			if len(body) == 0 || body[0] < 4 || body[0] > 6 {
+				return nil
+			}
+			e.Passphrase = true
+		default:
+			return nil
+		}
+	}
+}
+
+// openPGPHeader parses a packet header in either the old or the new format.
+// bodyLen is -1 for indeterminate and partial body lengths.
+func openPGPHeader(buf []byte) (tag byte, hdrLen int, bodyLen int64, ok bool) {
+	if len(buf) < 1 || buf[0]&0x80 == 0 {
+		return 0, 0, 0, false
+	}
+
+	if buf[0]&0x40 == 0 {
+		tag = buf[0] >> 2 & 0x0f
+		switch buf[0] & 3 {
+		case 0:
+			hdrLen = 2
+		case 1:
+			hdrLen = 3
+		case 2:
+			hdrLen = 5
+		case 3:
+			return tag, 1, -1, true
+		}
+		if len(buf) < hdrLen {
+			return tag, 0, 0, false
+		}
+		for _, b := range buf[1:hdrLen] {
+			bodyLen = bodyLen<<8 | int64(b)
+		}
+		return tag, hdrLen, bodyLen, true
+	}
+
+	tag = buf[0] & 0x3f
+	if len(buf) < 2 {
+		return tag, 0, 0, false
+	}
+	switch o := buf[1]; {
+	case o < 192:
+		return tag, 2, int64(o), true
+	case o < 224:
+		if len(buf) < 3 {
+			return tag, 0, 0, false
+		}
+		return tag, 3, int64(o-192)<<8 + int64(buf[2]) + 192, true
+	case o == 255:
+		if len(buf) < 6 {
+			return tag, 0, 0, false
+		}
+		return tag, 6, int64(binary.BigEndian.Uint32(buf[2:6])), true
+	default:
+		return tag, 2, -1, true
+	}
+}
+
+// pkeskKeyID returns the recipient of a public-key encrypted session key
+// packet: the key ID (version 3) or fingerprint (version 6) in hex, or ""
+// for an anonymous recipient.
+func pkeskKeyID(body []byte) (string, bool) {
+	if len(body) < 2 {
+		return "", false
+	}
+	var id []byte
+	switch body[0] {
+	case 3:
+		if len(body) < 10 {
+			return "", false
+		}
+		id = body[1:9]
+		if bytes.Equal(id, make([]byte, 8)) {
+			return "", true
+		}
+	case 6:
+		n := int(body[1])
+		if n == 0 {
+			return "", true
+		}
+		if len(body) < 2+n || n < 2 {
+			return "", false
+		}
+		id = body[3 : 2+n]
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("%X", id), true
+}
+
+// dearmor decodes as much of an ASCII-armored block as buf holds.
+func dearmor(buf []byte) []byte {
+	var b64 strings.Builder
+	for _, line := range strings.Split(string(buf), "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-----") || strings.HasPrefix(line, "=") {
+			break
+		}
+		// Armor headers such as "Version: ..." contain a colon, which is
+		// not a base64 character.
+		if strings.Contains(line, ":") {
+			continue
+		}
+		b64.WriteString(line)
+	}
+	s := b64.String()
+	data, _ := base64.StdEncoding.DecodeString(s[:len(s)/4*4])
+	return data
+}
+
+// isRetiredKey reports whether key, as recorded in Encryption.Keys, is the
+// retired key given. OpenPGP keys also match by key ID, so a retired
+// fingerprint matches the long or short key ID recorded in older messages,
+// and the other way round. A v4 key ID is the trailing end of its 40-digit
+// fingerprint, but a v6 key ID is the leading end of its 64-digit one
+// (RFC 9580, section 5.5.4).
+func isRetiredKey(key, retired string) bool {
+	if key == retired {
+		return true
+	}
+	retired = strings.ToUpper(strings.TrimPrefix(strings.ReplaceAll(retired, " ", ""), "0x"))
+	if len(retired) < 8 || !isHex(retired) || !isHex(key) {
+		return false
+	}
+	if len(key) == 64 || len(retired) == 64 {
+		return strings.HasPrefix(key, retired) || strings.HasPrefix(retired, key)
+	}
+	return strings.HasSuffix(key, retired) || strings.HasSuffix(retired, key)
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,123 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// pgpPacket encodes a packet with a new-format header.
+func pgpPacket(tag byte, body []byte) []byte {
+	return append([]byte{0xc0 | tag, byte(len(body))}, body...)
+}
+
+// pgpMessage builds an OpenPGP message encrypted to the given v3 key IDs,
+// with the session key packets in old format, as GnuPG writes them.
+func pgpMessage(keyIDs ...[]byte) []byte {
+	var msg []byte
+	for _, id := range keyIDs {
+		body := append([]byte{3}, id...)
+		body = append(body, 1, 0x08, 0x00, 0xff)
+		msg = append(msg, 0x84, byte(len(body)))
+		msg = append(msg, body...)
+	}
+	return append(msg, pgpPacket(pgpTagSEIPD, []byte{1, 0xde, 0xad, 0xbe, 0xef})...)
+}
+
+func armor(typ string, data []byte) []byte {
+	return []byte("-----BEGIN " + typ + "-----\nVersion: test\n\n" +
+		base64.StdEncoding.EncodeToString(data) + "\n=abcd\n-----END " + typ + "-----\n")
+}
+
+func TestInspectEncryption(t *testing.T) {
+	oldKey := []byte{0x0f, 0x96, 0xd5, 0x16, 0xd2, 0xeb, 0x90, 0x62}
+	newKey := []byte{0xf2, 0x40, 0x6d, 0x08, 0x63, 0xba, 0x41, 0x98}
+	v6 := append([]byte{6, 33, 6}, bytes.Repeat([]byte{0xab}, 32)...)
+
+	ageHeader := "age-encryption.org/v1\n" +
+		"-> X25519 SVrzdFfkPxf0LPHOUGB1gNb9E5Vr8EUDa9kxk04iQ0o\n" +
+		"0OrTkKHpE7klNLd0k+9Uam5hkQkzMxaqKcIPRIO1sNE\n" +
+		"-> ssh-ed25519 Xyz1AQ rKb8ZUmtG0Yf2UKNHHmKBm0yRp4RN1gQvYqT4Ul0MzY\n" +
+		"MlnUgc5YPMz7DFhzS2QrCJlJ1gwXx2y9bQ7+OGyCiKI\n" +
+		"--- Vn+54jqLUUQBNyuFaRnNY2jSBdWPMK0xKeDCPDAmYCs\n" +
+		"\x00\x01binary payload"
+
+	tests := []struct {
+		name string
+		data []byte
+		want *Encryption
+	}{
+		{"two keys", pgpMessage(oldKey, newKey),
+			&Encryption{Format: "OpenPGP", Keys: []string{"0F96D516D2EB9062", "F2406D0863BA4198"}}},
+		{"wildcard", pgpMessage(make([]byte, 8)),
+			&Encryption{Format: "OpenPGP", Anonymous: 1}},
+		{"armored", armor("PGP MESSAGE", pgpMessage(newKey)),
+			&Encryption{Format: "OpenPGP", Keys: []string{"F2406D0863BA4198"}}},
+		{"v6 and passphrase", append(append(pgpPacket(pgpTagPKESK, append(v6, 25, 0)),
+			pgpPacket(pgpTagSKESK, []byte{4, 9, 3, 0})...), pgpPacket(pgpTagAEAD, []byte{1})...),
+			&Encryption{Format: "OpenPGP", Keys: []string{strings.Repeat("AB", 32)}, Passphrase: true}},
+		{"age", []byte(ageHeader),
+			&Encryption{Format: "age", Keys: []string{"ssh-ed25519:Xyz1AQ"}, Anonymous: 1}},
+		{"armored age", armor("AGE ENCRYPTED FILE", []byte(ageHeader)),
+			&Encryption{Format: "age", Keys: []string{"ssh-ed25519:Xyz1AQ"}, Anonymous: 1}},
+		{"plain text", []byte("-- PostgreSQL database dump\n"), nil},
+		{"session key without data", pgpMessage(oldKey)[:12], nil},
+		{"signed, not encrypted", pgpPacket(4, []byte{3, 0, 8, 1}), nil},
+	}
+	for _, tt := range tests {
+		got := inspectEncryption(bytes.NewReader(tt.data), int64(len(tt.data)))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetiredKeys(t *testing.T) {
+	fingerprint := make([]byte, 32)
+	for i := range fingerprint {
+		fingerprint[i] = byte(0x10 + i)
+	}
+	v6 := append(append([]byte{6, 33, 6}, fingerprint...), 25, 0)
+	fsys := fstest.MapFS{
+		"old.gpg":   {Data: pgpMessage([]byte{0x0f, 0x96, 0xd5, 0x16, 0xd2, 0xeb, 0x90, 0x62})},
+		"new.gpg":   {Data: pgpMessage([]byte{0xf2, 0x40, 0x6d, 0x08, 0x63, 0xba, 0x41, 0x98})},
+		"v6.gpg":    {Data: append(pgpPacket(pgpTagPKESK, v6), pgpPacket(pgpTagAEAD, []byte{1})...)},
+		"plain.sql": {Data: []byte("SELECT 1;\n")},
+	}
+
+	// A retired fingerprint matches the key ID recorded in the message.
+	results := collect(t, New(WithRetiredKeys("4145 2229 68E5 B507 A8F7  9C03 0F96 D516 D2EB 9062")), fsys)
+	if r := results["old.gpg"]; r.Status != StatusError || !strings.Contains(r.Error, "retired key 0F96D516D2EB9062") {
+		t.Errorf("old.gpg: got %s (%s), want retired key error", r.Status, r.Error)
+	}
+	if r := results["old.gpg"]; r.Encryption == nil || len(r.Encryption.Retired) != 1 {
+		t.Errorf("old.gpg: Encryption = %+v, want one retired key", r.Encryption)
+	}
+	for _, name := range []string{"new.gpg", "v6.gpg", "plain.sql"} {
+		if r := results[name]; r.Status != StatusOK {
+			t.Errorf("%s: got %s (%s), want OK", name, r.Status, r.Error)
+		}
+	}
+
+	// A v6 key ID is the leading end of the fingerprint recorded, not the
+	// trailing end as with v4 keys.
+	results = collect(t, New(WithRetiredKeys("1011121314151617")), fsys)
+	if r := results["v6.gpg"]; r.Status != StatusError || !strings.Contains(r.Error, "retired key 101112") {
+		t.Errorf("v6.gpg: got %s (%s), want retired key error", r.Status, r.Error)
+	}
+	results = collect(t, New(WithRetiredKeys("28292A2B2C2D2E2F")), fsys)
+	if r := results["v6.gpg"]; r.Status != StatusOK {
+		t.Errorf("v6.gpg: got %s (%s), want OK for the fingerprint's trailing digits", r.Status, r.Error)
+	}
+
+	results = collect(t, New(WithRequiredEncryption()), fsys)
+	if r := results["plain.sql"]; r.Status != StatusError || !strings.Contains(r.Error, "not encrypted") {
+		t.Errorf("plain.sql: got %s (%s), want not encrypted error", r.Status, r.Error)
+	}
+	if r := results["old.gpg"]; r.Status != StatusOK {
+		t.Errorf("old.gpg: got %s (%s), want OK", r.Status, r.Error)
+	}
+}
@@ -0,0 +1,93 @@
+package validator
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+)
+
+// hexdumpRadius is how many bytes either side of a failing offset are
+// included in a forensic hexdump.
+const hexdumpRadius = 256
+
+// ReadFailure records where and how a read failed part-way through a file,
+// so storage engineers can correlate it with RAID controller and disk logs.
+type ReadFailure struct {
+	Offset  int64  `json:"offset"`
+	Errno   int    `json:"errno,omitempty"`
+	Hexdump string `json:"hexdump,omitempty"`
+}
+
+// readError is returned when reading a file fails after it was opened.
+type readError struct {
+	failure ReadFailure
+	err     error
+}
+
+func (e *readError) Error() string {
+	return fmt.Sprintf("read failed at byte offset %d: %v", e.failure.Offset, e.err)
+}
+
+func (e *readError) Unwrap() error {
+	return e.err
+}
+
+// newReadError captures forensic detail for a read that failed at offset.
+// If dump is set and r is not nil, the readable bytes around the offset are
+// hexdumped.
+func newReadError(r io.ReaderAt, offset int64, err error, dump bool) *readError {
+	e := &readError{failure: ReadFailure{Offset: offset}, err: err}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		e.failure.Errno = int(errno)
+	}
+	if dump && r != nil {
+		e.failure.Hexdump = hexdumpAround(r, offset)
+	}
+	return e
+}
+
+// hexdumpAround dumps up to hexdumpRadius bytes before and after offset,
+// labelled with absolute file offsets. Bytes that cannot be read are noted
+// rather than dumped.
+func hexdumpAround(r io.ReaderAt, offset int64) string {
+	start := (offset - hexdumpRadius) &^ 15
+	if start < 0 {
+		start = 0
+	}
+
+	before := make([]byte, offset-start)
+	n, _ := r.ReadAt(before, start)
+	before = before[:n]
+
+	after := make([]byte, hexdumpRadius)
+	m, afterErr := r.ReadAt(after, offset)
+	after = after[:m]
+
+	var b strings.Builder
+	writeHexdump(&b, append(before, after...), start)
+	if int64(len(before)) < offset-start {
+		fmt.Fprintf(&b, "bytes from offset %d before the failure could not be read\n", start+int64(len(before)))
+	}
+	if afterErr != nil && afterErr != io.EOF {
+		fmt.Fprintf(&b, "bytes from offset %d are unreadable: %v\n", offset+int64(m), afterErr)
+	}
+	return b.String()
+}
+
+// writeHexdump writes data in hex.Dump layout with offsets starting at base.
+func writeHexdump(b *strings.Builder, data []byte, base int64) {
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		line := hex.Dump(data[i:end])
+		line = strings.TrimSuffix(line, "\n")
+		fmt.Fprintf(b, "%08x%s\n", base+int64(i), line[8:])
+	}
+}
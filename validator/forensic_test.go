@@ -0,0 +1,53 @@
+package validator
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// badSectorReader serves data but fails with EIO for reads touching
+// [bad, bad+512).
+type badSectorReader struct {
+	data []byte
+	bad  int64
+}
+
+func (r badSectorReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[off:])
+	if off < r.bad+512 && off+int64(n) > r.bad {
+		if off >= r.bad {
+			return 0, syscall.EIO
+		}
+		return int(r.bad - off), syscall.EIO
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestNewReadError(t *testing.T) {
+	data := []byte(strings.Repeat("backup", 400))
+	r := badSectorReader{data: data, bad: 1024}
+
+	e := newReadError(r, 1024, &os.PathError{Op: "read", Path: "backup", Err: syscall.EIO}, true)
+	if e.failure.Offset != 1024 || e.failure.Errno != int(syscall.EIO) {
+		t.Errorf("got offset %d errno %d, want 1024 and EIO", e.failure.Offset, e.failure.Errno)
+	}
+	if !strings.HasPrefix(e.failure.Hexdump, "00000300  ") {
+		t.Errorf("hexdump should start 256 bytes before the failure:\n%s", e.failure.Hexdump)
+	}
+	if !strings.Contains(e.failure.Hexdump, "bytes from offset 1024 are unreadable") {
+		t.Errorf("hexdump should note the unreadable region:\n%s", e.failure.Hexdump)
+	}
+
+	if e := newReadError(r, 1024, syscall.EIO, false); e.failure.Hexdump != "" {
+		t.Errorf("hexdump captured without being requested")
+	}
+}
@@ -0,0 +1,78 @@
+package validator
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
+// memberLister lists the members of a tar archive from the same stream
+// that is being hashed, so indexing needs no second read of the file.
+type memberLister struct {
+	pw      *io.PipeWriter
+	done    chan struct{}
+	members []string
+}
+
+// newMemberLister returns a lister for tar archives, compressed or not,
+// judged by name. It returns nil for other files.
+func newMemberLister(name string) *memberLister {
+	lower := strings.ToLower(name)
+	gzipped := strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+	if !gzipped && !strings.HasSuffix(lower, ".tar") {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	l := &memberLister{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		// Whatever happens, keep draining so the hashing side never blocks.
+		defer io.Copy(io.Discard, pr)
+
+		var r io.Reader = pr
+		if gzipped {
+			zr, err := gzip.NewReader(pr)
+			if err != nil {
+				return
+			}
+			r = zr
+		}
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				return
+			}
+			l.members = append(l.members, hdr.Name)
+		}
+	}()
+	return l
+}
+
+func (l *memberLister) Write(p []byte) (int, error) {
+	return l.pw.Write(p)
+}
+
+// finish ends the stream and returns the members found. A damaged archive
+// yields the members listed before the damage.
+func (l *memberLister) finish() []string {
+	l.pw.Close()
+	<-l.done
+	return l.members
+}
+
+// zipMembers lists a zip archive's members from its central directory.
+func zipMembers(ra io.ReaderAt, size int64) []string {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil
+	}
+	members := make([]string, 0, len(zr.File))
+	for _, f := range zr.File {
+		members = append(members, f.Name)
+	}
+	return members
+}
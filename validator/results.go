@@ -0,0 +1,63 @@
+package validator
+
+import "context"
+
+// Results iterates over the results of ValidateTree. Results must be closed
+// if iteration stops before Next returns false.
+type Results struct {
+	ch     <-chan Result
+	cancel context.CancelFunc
+	cur    Result
+	err    error
+	closed bool
+}
+
+// Next advances to the next result, blocking until it is available. It
+// returns false when the run is complete or has been cancelled.
+func (it *Results) Next() bool {
+	r, ok := <-it.ch
+	if !ok {
+		it.cancel()
+		return false
+	}
+	it.cur = r
+	return true
+}
+
+// Result returns the current result.
+func (it *Results) Result() Result {
+	return it.cur
+}
+
+// Err returns the error that ended the run early, such as the context being
+// cancelled. It should be checked after Next returns false.
+func (it *Results) Err() error {
+	if it.closed {
+		return nil
+	}
+	return it.err
+}
+
+// Close stops the run and releases its resources. It is safe to call more
+// than once.
+func (it *Results) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.cancel()
+	for range it.ch {
+	}
+	it.closed = true
+	return nil
+}
+
+// Collect returns all remaining results and closes the iterator.
+func (it *Results) Collect() ([]Result, error) {
+	var results []Result
+	for it.Next() {
+		results = append(results, it.Result())
+	}
+	err := it.Err()
+	it.Close()
+	return results, err
+}
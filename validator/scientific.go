@@ -0,0 +1,758 @@
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Scientific data formats (HDF5 and NetCDF) are validated structurally:
+// the superblock/header is parsed, the recorded end of data is compared with
+// the real file size to detect truncation, and datasets are counted.
+
+var hdf5Signature = []byte("\x89HDF\r\n\x1a\n")
+
+const (
+	hdf5MaxObjects = 1 << 20
+
+	hdf5MsgLinkInfo     = 0x0002
+	hdf5MsgLink         = 0x0006
+	hdf5MsgLayout       = 0x0008
+	hdf5MsgContinuation = 0x0010
+	hdf5MsgSymbolTable  = 0x0011
+)
+
+var errNotScientific = errors.New("not a scientific data file")
+
+// inspectScientificFormat identifies HDF5 and NetCDF files and validates
+// their structure. It returns errNotScientific for any other file.
+func inspectScientificFormat(r io.ReaderAt, size int64, name string) (format, info string, err error) {
+	magic := make([]byte, 8)
+	if size >= 4 {
+		if _, err := r.ReadAt(magic[:4], 0); err != nil {
+			return "", "", err
+		}
+		if bytes.Equal(magic[:3], []byte("CDF")) {
+			return inspectNetCDF(r, size, magic[3])
+		}
+	}
+
+	// The HDF5 superblock may be preceded by a user block, so it is
+	// searched for at offset 0 and at every power of two from 512.
+	for off := int64(0); off+8 <= size; off = nextHDF5Offset(off) {
+		if _, err := r.ReadAt(magic, off); err != nil {
+			return "", "", err
+		}
+		if bytes.Equal(magic, hdf5Signature) {
+			format = "HDF5"
+			if ext := strings.ToLower(filepath.Ext(name)); ext == ".nc" || ext == ".nc4" {
+				format = "NetCDF-4/HDF5"
+			}
+			info, err = inspectHDF5(r, size, off)
+			return format, info, err
+		}
+	}
+
+	return "", "", errNotScientific
+}
+
+func nextHDF5Offset(off int64) int64 {
+	if off == 0 {
+		return 512
+	}
+	return off * 2
+}
+
+type hdf5File struct {
+	r       io.ReaderAt
+	size    int64
+	base    int64
+	offSize int
+	lenSize int
+	undef   uint64
+
+	visited    map[uint64]bool
+	datasets   int
+	groups     int
+	incomplete bool
+}
+
+func inspectHDF5(r io.ReaderAt, size, sbOffset int64) (string, error) {
+	f := &hdf5File{r: r, size: size, visited: make(map[uint64]bool)}
+
+	head, err := f.readAbs(sbOffset, 16)
+	if err != nil {
+		return "", fmt.Errorf("HDF5: superblock truncated")
+	}
+	version := head[8]
+
+	var eof, root uint64
+	switch version {
+	case 0, 1:
+		f.offSize, f.lenSize = int(head[13]), int(head[14])
+		if err := f.checkSizes(); err != nil {
+			return "", err
+		}
+		fixed := 24
+		if version == 1 {
+			fixed += 4
+		}
+		o := f.offSize
+		sb, err := f.readAbs(sbOffset, int64(fixed+4*o+2*o+24))
+		if err != nil {
+			return "", fmt.Errorf("HDF5: superblock truncated")
+		}
+		eof = leUint(sb[fixed+2*o : fixed+3*o])
+		// Root group symbol table entry: link name offset, then object header address.
+		entry := sb[fixed+4*o:]
+		root = leUint(entry[o : 2*o])
+	case 2, 3:
+		f.offSize, f.lenSize = int(head[9]), int(head[10])
+		if err := f.checkSizes(); err != nil {
+			return "", err
+		}
+		o := f.offSize
+		n := 12 + 4*o
+		sb, err := f.readAbs(sbOffset, int64(n+4))
+		if err != nil {
+			return "", fmt.Errorf("HDF5: superblock truncated")
+		}
+		if binary.LittleEndian.Uint32(sb[n:]) != lookup3(sb[:n], 0) {
+			return "", fmt.Errorf("HDF5: superblock checksum mismatch")
+		}
+		eof = leUint(sb[12+2*o : 12+3*o])
+		root = leUint(sb[12+3*o : 12+4*o])
+	default:
+		return fmt.Sprintf("superblock v%d (unsupported, structure not inspected)", version), nil
+	}
+
+	// Addresses are relative to the base address, which is normally where
+	// the superblock is. If the file was embedded in another (e.g. as a
+	// tar member) the recorded base is stale, so, like libhdf5, use the
+	// superblock's actual offset.
+	f.base = sbOffset
+
+	if end := uint64(f.base) + eof; end > uint64(size) {
+		return "", fmt.Errorf("HDF5: truncated: end-of-file address %d exceeds file size %d", end, size)
+	}
+
+	if err := f.visitObject(root); err != nil {
+		return "", err
+	}
+
+	info := fmt.Sprintf("superblock v%d, %d datasets, %d groups", version, f.datasets, f.groups)
+	if f.incomplete {
+		info += " (some groups use storage that was not inspected)"
+	}
+	return info, nil
+}
+
+func (f *hdf5File) checkSizes() error {
+	valid := func(n int) bool { return n == 2 || n == 4 || n == 8 }
+	if !valid(f.offSize) || !valid(f.lenSize) {
+		return fmt.Errorf("HDF5: invalid superblock field sizes (offsets %d, lengths %d)", f.offSize, f.lenSize)
+	}
+	f.undef = 1<<(8*uint(f.offSize)) - 1
+	if f.offSize == 8 {
+		f.undef = ^uint64(0)
+	}
+	return nil
+}
+
+// readAbs reads n bytes at an absolute file offset.
+func (f *hdf5File) readAbs(off, n int64) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > f.size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	if _, err := f.r.ReadAt(buf, off); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// read reads n bytes at an address relative to the base address.
+func (f *hdf5File) read(addr uint64, n int) ([]byte, error) {
+	buf, err := f.readAbs(f.base+int64(addr), int64(n))
+	if err != nil {
+		return nil, fmt.Errorf("HDF5: structure at address %d lies beyond end of file", addr)
+	}
+	return buf, nil
+}
+
+type hdf5Message struct {
+	typ  uint16
+	data []byte
+}
+
+func (f *hdf5File) visitObject(addr uint64) error {
+	if addr == f.undef || f.visited[addr] {
+		return nil
+	}
+	if len(f.visited) >= hdf5MaxObjects {
+		f.incomplete = true
+		return nil
+	}
+	f.visited[addr] = true
+
+	msgs, err := f.objectMessages(addr)
+	if err != nil {
+		return err
+	}
+
+	isGroup := false
+	for _, m := range msgs {
+		switch m.typ {
+		case hdf5MsgLayout:
+			f.datasets++
+		case hdf5MsgSymbolTable:
+			isGroup = true
+			if len(m.data) < f.offSize {
+				return fmt.Errorf("HDF5: malformed symbol table message in object at %d", addr)
+			}
+			if err := f.visitGroupBTree(leUint(m.data[:f.offSize]), 0); err != nil {
+				return err
+			}
+		case hdf5MsgLinkInfo:
+			isGroup = true
+			// Dense link storage lives in a fractal heap, which is not walked.
+			if len(m.data) >= 2 {
+				p := 2
+				if m.data[1]&0x01 != 0 {
+					p += 8
+				}
+				if len(m.data) >= p+f.offSize && leUint(m.data[p:p+f.offSize]) != f.undef {
+					f.incomplete = true
+				}
+			}
+		case hdf5MsgLink:
+			isGroup = true
+			target, ok := f.hardLinkTarget(m.data)
+			if ok {
+				if err := f.visitObject(target); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if isGroup {
+		f.groups++
+	}
+	return nil
+}
+
+// objectMessages returns the header messages of the object at addr,
+// following continuation blocks.
+func (f *hdf5File) objectMessages(addr uint64) ([]hdf5Message, error) {
+	prefix, err := f.read(addr, 4)
+	if err != nil {
+		return nil, err
+	}
+	if string(prefix) == "OHDR" {
+		return f.objectMessagesV2(addr)
+	}
+	if prefix[0] != 1 {
+		return nil, fmt.Errorf("HDF5: corrupt object header at address %d (version %d)", addr, prefix[0])
+	}
+
+	hdr, err := f.read(addr, 16)
+	if err != nil {
+		return nil, err
+	}
+	total := int(binary.LittleEndian.Uint16(hdr[2:4]))
+	block, err := f.read(addr+16, int(binary.LittleEndian.Uint32(hdr[8:12])))
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []hdf5Message
+	blocks := [][]byte{block}
+	for len(blocks) > 0 && len(msgs) < total {
+		b := blocks[0]
+		blocks = blocks[1:]
+		for p := 0; p+8 <= len(b) && len(msgs) < total; {
+			typ := binary.LittleEndian.Uint16(b[p:])
+			n := int(binary.LittleEndian.Uint16(b[p+2:]))
+			if p+8+n > len(b) {
+				return nil, fmt.Errorf("HDF5: corrupt object header at address %d", addr)
+			}
+			data := b[p+8 : p+8+n]
+			p += 8 + n
+			msgs = append(msgs, hdf5Message{typ, data})
+			if typ == hdf5MsgContinuation {
+				cont, err := f.continuation(data)
+				if err != nil {
+					return nil, err
+				}
+				blocks = append(blocks, cont)
+			}
+		}
+	}
+	return msgs, nil
+}
+
+func (f *hdf5File) objectMessagesV2(addr uint64) ([]hdf5Message, error) {
+	head, err := f.read(addr, 6)
+	if err != nil {
+		return nil, err
+	}
+	if head[4] != 2 {
+		return nil, fmt.Errorf("HDF5: corrupt object header at address %d (version %d)", addr, head[4])
+	}
+	flags := head[5]
+	p := 6
+	if flags&0x20 != 0 {
+		p += 16
+	}
+	if flags&0x10 != 0 {
+		p += 4
+	}
+	width := 1 << (flags & 0x03)
+	if head, err = f.read(addr, p+width); err != nil {
+		return nil, err
+	}
+	chunk := int(leUint(head[p : p+width]))
+	p += width
+
+	block, err := f.read(addr, p+chunk+4)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(block[p+chunk:]) != lookup3(block[:p+chunk], 0) {
+		return nil, fmt.Errorf("HDF5: object header checksum mismatch at address %d", addr)
+	}
+
+	var msgs []hdf5Message
+	chunks := [][]byte{block[p : p+chunk]}
+	for len(chunks) > 0 {
+		b := chunks[0]
+		chunks = chunks[1:]
+		hlen := 4
+		if flags&0x04 != 0 {
+			hlen += 2
+		}
+		for q := 0; q+hlen <= len(b); {
+			typ := uint16(b[q])
+			n := int(binary.LittleEndian.Uint16(b[q+1:]))
+			if q+hlen+n > len(b) {
+				return nil, fmt.Errorf("HDF5: corrupt object header at address %d", addr)
+			}
+			data := b[q+hlen : q+hlen+n]
+			q += hlen + n
+			msgs = append(msgs, hdf5Message{typ, data})
+			if typ == hdf5MsgContinuation {
+				cont, err := f.continuation(data)
+				if err != nil {
+					return nil, err
+				}
+				if len(cont) < 8 || string(cont[:4]) != "OCHK" {
+					return nil, fmt.Errorf("HDF5: corrupt object header continuation for address %d", addr)
+				}
+				end := len(cont) - 4
+				if binary.LittleEndian.Uint32(cont[end:]) != lookup3(cont[:end], 0) {
+					return nil, fmt.Errorf("HDF5: object header checksum mismatch for address %d", addr)
+				}
+				chunks = append(chunks, cont[4:end])
+			}
+		}
+	}
+	return msgs, nil
+}
+
+func (f *hdf5File) continuation(data []byte) ([]byte, error) {
+	if len(data) < f.offSize+f.lenSize {
+		return nil, errors.New("HDF5: malformed object header continuation message")
+	}
+	off := leUint(data[:f.offSize])
+	n := leUint(data[f.offSize : f.offSize+f.lenSize])
+	if n > uint64(f.size) {
+		return nil, errors.New("HDF5: malformed object header continuation message")
+	}
+	return f.read(off, int(n))
+}
+
+// visitGroupBTree walks a version 1 group B-tree and visits every object
+// referenced from its symbol table nodes.
+func (f *hdf5File) visitGroupBTree(addr uint64, depth int) error {
+	if addr == f.undef {
+		return nil
+	}
+	if depth > 64 {
+		return fmt.Errorf("HDF5: group B-tree at address %d is too deep", addr)
+	}
+	o, l := f.offSize, f.lenSize
+	head, err := f.read(addr, 8+2*o)
+	if err != nil {
+		return err
+	}
+	if string(head[:4]) != "TREE" || head[4] != 0 {
+		return fmt.Errorf("HDF5: corrupt group B-tree node at address %d", addr)
+	}
+	level := head[5]
+	entries := int(binary.LittleEndian.Uint16(head[6:8]))
+
+	body, err := f.read(addr+uint64(8+2*o), entries*(l+o)+l)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < entries; i++ {
+		p := i*(l+o) + l
+		child := leUint(body[p : p+o])
+		if level > 0 {
+			err = f.visitGroupBTree(child, depth+1)
+		} else {
+			err = f.visitSymbolTableNode(child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *hdf5File) visitSymbolTableNode(addr uint64) error {
+	head, err := f.read(addr, 8)
+	if err != nil {
+		return err
+	}
+	if string(head[:4]) != "SNOD" {
+		return fmt.Errorf("HDF5: corrupt symbol table node at address %d", addr)
+	}
+	n := int(binary.LittleEndian.Uint16(head[6:8]))
+	size := 2*f.offSize + 24
+	entries, err := f.read(addr+8, n*size)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		e := entries[i*size:]
+		if err := f.visitObject(leUint(e[f.offSize : 2*f.offSize])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hardLinkTarget decodes a link message and returns the object header
+// address for hard links.
+func (f *hdf5File) hardLinkTarget(data []byte) (uint64, bool) {
+	if len(data) < 2 || data[0] != 1 {
+		return 0, false
+	}
+	flags := data[1]
+	p := 2
+	linkType := byte(0)
+	if flags&0x08 != 0 {
+		if len(data) <= p {
+			return 0, false
+		}
+		linkType = data[p]
+		p++
+	}
+	if flags&0x04 != 0 {
+		p += 8
+	}
+	if flags&0x10 != 0 {
+		p++
+	}
+	width := 1 << (flags & 0x03)
+	if len(data) < p+width {
+		return 0, false
+	}
+	nameLen := int(leUint(data[p : p+width]))
+	p += width
+	if nameLen < 0 || nameLen > len(data)-p {
+		return 0, false
+	}
+	p += nameLen
+	if linkType != 0 || len(data) < p+f.offSize {
+		return 0, false
+	}
+	return leUint(data[p : p+f.offSize]), true
+}
+
+func leUint(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// lookup3 is Bob Jenkins' hashlittle, used by HDF5 for metadata checksums.
+func lookup3(k []byte, initval uint32) uint32 {
+	rot := func(x uint32, n uint) uint32 { return x<<n | x>>(32-n) }
+	a := 0xdeadbeef + uint32(len(k)) + initval
+	b, c := a, a
+
+	for len(k) > 12 {
+		a += binary.LittleEndian.Uint32(k[0:])
+		b += binary.LittleEndian.Uint32(k[4:])
+		c += binary.LittleEndian.Uint32(k[8:])
+		a -= c
+		a ^= rot(c, 4)
+		c += b
+		b -= a
+		b ^= rot(a, 6)
+		a += c
+		c -= b
+		c ^= rot(b, 8)
+		b += a
+		a -= c
+		a ^= rot(c, 16)
+		c += b
+		b -= a
+		b ^= rot(a, 19)
+		a += c
+		c -= b
+		c ^= rot(b, 4)
+		b += a
+		k = k[12:]
+	}
+	if len(k) == 0 {
+		return c
+	}
+
+	var tail [12]byte
+	copy(tail[:], k)
+	a += binary.LittleEndian.Uint32(tail[0:])
+	b += binary.LittleEndian.Uint32(tail[4:])
+	c += binary.LittleEndian.Uint32(tail[8:])
+
+	c ^= b
+	c -= rot(b, 14)
+	a ^= c
+	a -= rot(c, 11)
+	b ^= a
+	b -= rot(a, 25)
+	c ^= b
+	c -= rot(b, 16)
+	a ^= c
+	a -= rot(c, 4)
+	b ^= a
+	b -= rot(a, 14)
+	c ^= b
+	c -= rot(b, 24)
+	return c
+}
+
+// NetCDF classic, 64-bit offset and CDF-5 headers.
+
+const (
+	cdfDimension = 0x0A
+	cdfVariable  = 0x0B
+	cdfAttribute = 0x0C
+
+	cdfStreaming = 0xFFFFFFFF
+)
+
+var cdfTypeSizes = map[uint32]int64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 4, 6: 8,
+	7: 1, 8: 2, 9: 4, 10: 8, 11: 8,
+}
+
+type cdfReader struct {
+	r       *bufio.Reader
+	version byte
+	err     error
+}
+
+func (c *cdfReader) uint(n int) uint64 {
+	if c.err != nil {
+		return 0
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		c.err = err
+		return 0
+	}
+	var v uint64
+	for _, b := range buf {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// count reads a NON_NEG value, which is 64-bit in CDF-5.
+func (c *cdfReader) count() uint64 {
+	if c.version == 5 {
+		return c.uint(8)
+	}
+	return c.uint(4)
+}
+
+func (c *cdfReader) skip(n uint64) {
+	if c.err != nil {
+		return
+	}
+	n = (n + 3) &^ 3
+	if n > 1<<31 {
+		c.err = errors.New("implausible header field length")
+		return
+	}
+	if _, err := c.r.Discard(int(n)); err != nil {
+		c.err = err
+	}
+}
+
+// list reads a list tag and its element count, checking the tag. It
+// returns 0 if the list is invalid.
+func (c *cdfReader) list(tag uint64) uint64 {
+	t := c.uint(4)
+	n := c.count()
+	if c.err == nil && t != 0 && t != tag {
+		c.err = fmt.Errorf("unexpected list tag %#x", t)
+	}
+	if c.err == nil && n > 1<<24 {
+		c.err = fmt.Errorf("implausible element count %d", n)
+	}
+	if c.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (c *cdfReader) attributes() {
+	n := c.list(cdfAttribute)
+	for i := uint64(0); i < n && c.err == nil; i++ {
+		c.skip(c.count())
+		typ := uint32(c.uint(4))
+		nelems := c.count()
+		size, ok := cdfTypeSizes[typ]
+		if c.err == nil && !ok {
+			c.err = fmt.Errorf("unknown attribute type %d", typ)
+		}
+		c.skip(nelems * uint64(size))
+	}
+}
+
+type cdfVar struct {
+	record bool
+	size   int64
+	begin  int64
+}
+
+func inspectNetCDF(r io.ReaderAt, size int64, version byte) (string, string, error) {
+	var format string
+	switch version {
+	case 1:
+		format = "NetCDF classic"
+	case 2:
+		format = "NetCDF 64-bit offset"
+	case 5:
+		format = "NetCDF CDF-5"
+	default:
+		return "", "", errNotScientific
+	}
+
+	c := &cdfReader{r: bufio.NewReader(io.NewSectionReader(r, 4, size-4)), version: version}
+	fail := func() (string, string, error) {
+		if errors.Is(c.err, io.EOF) || errors.Is(c.err, io.ErrUnexpectedEOF) {
+			return format, "", errors.New("NetCDF: truncated header")
+		}
+		return format, "", fmt.Errorf("NetCDF: corrupt header: %v", c.err)
+	}
+
+	numrecs := c.count()
+	streaming := version != 5 && numrecs == cdfStreaming
+
+	ndims := c.list(cdfDimension)
+	if c.err != nil {
+		return fail()
+	}
+	dims := make([]uint64, 0, ndims)
+	for i := uint64(0); i < ndims && c.err == nil; i++ {
+		c.skip(c.count())
+		dims = append(dims, c.count())
+	}
+	c.attributes()
+
+	nvars := c.list(cdfVariable)
+	if c.err != nil {
+		return fail()
+	}
+	vars := make([]cdfVar, 0, nvars)
+	for i := uint64(0); i < nvars && c.err == nil; i++ {
+		c.skip(c.count())
+		rank := c.count()
+		if rank > 1024 {
+			c.err = fmt.Errorf("implausible variable rank %d", rank)
+		}
+		v := cdfVar{size: 1}
+		for j := uint64(0); j < rank && c.err == nil; j++ {
+			id := c.count()
+			if id >= uint64(len(dims)) {
+				c.err = fmt.Errorf("variable references unknown dimension %d", id)
+				break
+			}
+			if dims[id] == 0 {
+				v.record = j == 0
+				continue
+			}
+			v.size *= int64(dims[id])
+		}
+		c.attributes()
+		typeSize, ok := cdfTypeSizes[uint32(c.uint(4))]
+		if c.err == nil && !ok {
+			c.err = errors.New("unknown variable type")
+		}
+		v.size *= typeSize
+		c.count() // vsize, recomputed above because it saturates for large variables
+		if version == 1 {
+			v.begin = int64(c.uint(4))
+		} else {
+			v.begin = int64(c.uint(8))
+		}
+		vars = append(vars, v)
+	}
+	if c.err != nil {
+		return fail()
+	}
+
+	// Record variables are interleaved; each is padded to four bytes unless
+	// it is the only record variable.
+	var recSize, recStart int64 = 0, -1
+	var records []cdfVar
+	for _, v := range vars {
+		if v.record {
+			records = append(records, v)
+		}
+	}
+	for _, v := range records {
+		if len(records) == 1 {
+			recSize += v.size
+		} else {
+			recSize += (v.size + 3) &^ 3
+		}
+		if recStart < 0 || v.begin < recStart {
+			recStart = v.begin
+		}
+	}
+	if streaming && recSize > 0 && size > recStart {
+		numrecs = uint64((size - recStart) / recSize)
+	}
+
+	var expected int64
+	for _, v := range vars {
+		end := v.begin + v.size
+		if v.record {
+			if numrecs == 0 {
+				continue
+			}
+			end = v.begin + int64(numrecs-1)*recSize + v.size
+		}
+		if end > expected {
+			expected = end
+		}
+	}
+	if expected > size {
+		return format, "", fmt.Errorf("NetCDF: truncated: data extends to byte %d but file size is %d", expected, size)
+	}
+
+	return format, fmt.Sprintf("%d variables, %d dimensions, %d records", len(vars), len(dims), numrecs), nil
+}
@@ -0,0 +1,273 @@
+package validator
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const undef = ^uint64(0)
+
+func le(b *bytes.Buffer, n int, v uint64) {
+	for i := 0; i < n; i++ {
+		b.WriteByte(byte(v >> (8 * i)))
+	}
+}
+
+// buildHDF5v0 builds a file with a version 0 superblock whose root group
+// holds two datasets in a symbol table.
+func buildHDF5v0() []byte {
+	const root, btree, snod, ds1, ds2, eof = 96, 136, 184, 272, 304, 336
+	var b bytes.Buffer
+
+	b.Write(hdf5Signature)
+	b.Write([]byte{0, 0, 0, 0, 0, 8, 8, 0})
+	le(&b, 2, 4)
+	le(&b, 2, 16)
+	le(&b, 4, 0)
+	le(&b, 8, 0)
+	le(&b, 8, undef)
+	le(&b, 8, eof)
+	le(&b, 8, undef)
+	le(&b, 8, 0)
+	le(&b, 8, root)
+	le(&b, 4, 1)
+	le(&b, 4, 0)
+	le(&b, 8, btree)
+	le(&b, 8, undef)
+
+	// Root object header with a symbol table message.
+	b.Write([]byte{1, 0})
+	le(&b, 2, 1)
+	le(&b, 4, 1)
+	le(&b, 4, 24)
+	le(&b, 4, 0)
+	le(&b, 2, hdf5MsgSymbolTable)
+	le(&b, 2, 16)
+	le(&b, 4, 0)
+	le(&b, 8, btree)
+	le(&b, 8, undef)
+
+	b.WriteString("TREE")
+	b.Write([]byte{0, 0})
+	le(&b, 2, 1)
+	le(&b, 8, undef)
+	le(&b, 8, undef)
+	le(&b, 8, 0)
+	le(&b, 8, snod)
+	le(&b, 8, 0)
+
+	b.WriteString("SNOD")
+	b.Write([]byte{1, 0})
+	le(&b, 2, 2)
+	for _, addr := range []uint64{ds1, ds2} {
+		le(&b, 8, 0)
+		le(&b, 8, addr)
+		le(&b, 8, 0)
+		le(&b, 16, 0)
+	}
+
+	for i := 0; i < 2; i++ {
+		b.Write([]byte{1, 0})
+		le(&b, 2, 1)
+		le(&b, 4, 1)
+		le(&b, 4, 16)
+		le(&b, 4, 0)
+		le(&b, 2, hdf5MsgLayout)
+		le(&b, 2, 8)
+		le(&b, 4, 0)
+		le(&b, 8, 0)
+	}
+	return b.Bytes()
+}
+
+// buildHDF5v2 builds a file with a version 2 superblock whose root group
+// links to two datasets with compact link messages.
+func buildHDF5v2() []byte {
+	const root, ds1, ds2, eof = 48, 97, 120, 143
+	var b bytes.Buffer
+
+	b.Write(hdf5Signature)
+	b.Write([]byte{2, 8, 8, 0})
+	le(&b, 8, 0)
+	le(&b, 8, undef)
+	le(&b, 8, eof)
+	le(&b, 8, root)
+	le(&b, 4, uint64(lookup3(b.Bytes(), 0)))
+
+	ohdr := func(msgs []byte) {
+		start := b.Len()
+		b.WriteString("OHDR")
+		b.Write([]byte{2, 0, byte(len(msgs))})
+		b.Write(msgs)
+		le(&b, 4, uint64(lookup3(b.Bytes()[start:], 0)))
+	}
+
+	var links bytes.Buffer
+	for _, addr := range []uint64{ds1, ds2} {
+		links.WriteByte(hdf5MsgLink)
+		le(&links, 2, 15)
+		links.WriteByte(0)
+		links.Write([]byte{1, 0, 4})
+		links.WriteString("data")
+		le(&links, 8, addr)
+	}
+	ohdr(links.Bytes())
+
+	var layout bytes.Buffer
+	layout.WriteByte(hdf5MsgLayout)
+	le(&layout, 2, 8)
+	layout.WriteByte(0)
+	le(&layout, 8, 0)
+	ohdr(layout.Bytes())
+	ohdr(layout.Bytes())
+	return b.Bytes()
+}
+
+func TestLookup3(t *testing.T) {
+	if got := lookup3(nil, 0); got != 0xdeadbeef {
+		t.Errorf("lookup3(empty) = %#x, want 0xdeadbeef", got)
+	}
+	if got := lookup3([]byte("Four score and seven years ago"), 0); got != 0x17770551 {
+		t.Errorf("lookup3 = %#x, want 0x17770551", got)
+	}
+}
+
+func TestInspectHDF5(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		info string
+	}{
+		{"superblock v0", buildHDF5v0(), "superblock v0, 2 datasets, 1 groups"},
+		{"superblock v2", buildHDF5v2(), "superblock v2, 2 datasets, 1 groups"},
+	}
+	for _, tt := range tests {
+		format, info, err := inspectScientificFormat(bytes.NewReader(tt.data), int64(len(tt.data)), "data.h5")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if format != "HDF5" || info != tt.info {
+			t.Errorf("%s: got %q %q, want HDF5 %q", tt.name, format, info, tt.info)
+		}
+	}
+}
+
+func TestInspectHDF5Damage(t *testing.T) {
+	data := buildHDF5v0()
+	truncated := data[:len(data)-10]
+	_, _, err := inspectScientificFormat(bytes.NewReader(truncated), int64(len(truncated)), "data.h5")
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("truncated file: got %v, want truncation error", err)
+	}
+
+	data = buildHDF5v0()
+	copy(data[184:], "XXXX")
+	_, _, err = inspectScientificFormat(bytes.NewReader(data), int64(len(data)), "data.h5")
+	if err == nil || !strings.Contains(err.Error(), "symbol table node") {
+		t.Errorf("corrupt node: got %v, want symbol table node error", err)
+	}
+
+	data = buildHDF5v2()
+	data[60] ^= 0xff
+	_, _, err = inspectScientificFormat(bytes.NewReader(data), int64(len(data)), "data.h5")
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("corrupt object header: got %v, want checksum error", err)
+	}
+
+	// A link message with an 8-byte name length that overflows int.
+	f := &hdf5File{offSize: 8}
+	link := append([]byte{1, 0x03}, bytes.Repeat([]byte{0xff}, 8)...)
+	link = append(link, make([]byte, 16)...)
+	if _, ok := f.hardLinkTarget(link); ok {
+		t.Error("link with overlong name: got a target")
+	}
+}
+
+func TestHDF5InTar(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for name, h5 := range map[string][]byte{"v0.tar": buildHDF5v0(), "v2.tar": buildHDF5v2()} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "run1/data.h5", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(h5))})
+		tw.Write(h5)
+		tw.Close()
+		fsys[name] = &fstest.MapFile{Data: buf.Bytes()}
+	}
+
+	for name, r := range collect(t, New(), fsys) {
+		if r.Status != StatusOK {
+			t.Errorf("%s: got %s (%s), want OK", name, r.Status, r.Error)
+		}
+	}
+}
+
+func TestInspectNetCDF(t *testing.T) {
+	var b bytes.Buffer
+	u32 := func(v uint32) { binary.Write(&b, binary.BigEndian, v) }
+	name := func(s string) {
+		u32(uint32(len(s)))
+		b.WriteString(s)
+		b.Write(make([]byte, (4-len(s)%4)%4))
+	}
+
+	b.WriteString("CDF\x01")
+	u32(2)
+	u32(cdfDimension)
+	u32(2)
+	name("time")
+	u32(0)
+	name("x")
+	u32(3)
+	u32(0)
+	u32(0)
+	u32(cdfVariable)
+	u32(2)
+	name("x")
+	u32(1)
+	u32(1)
+	u32(0)
+	u32(0)
+	u32(5)
+	u32(12)
+	xBegin := b.Len()
+	u32(0)
+	name("t")
+	u32(1)
+	u32(0)
+	u32(0)
+	u32(0)
+	u32(6)
+	u32(8)
+	tBegin := b.Len()
+	u32(0)
+
+	data := b.Bytes()
+	binary.BigEndian.PutUint32(data[xBegin:], uint32(len(data)))
+	binary.BigEndian.PutUint32(data[tBegin:], uint32(len(data)+12))
+	data = append(data, make([]byte, 12+2*8)...)
+
+	format, info, err := inspectScientificFormat(bytes.NewReader(data), int64(len(data)), "data.nc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if format != "NetCDF classic" || info != "2 variables, 2 dimensions, 2 records" {
+		t.Errorf("got %q %q", format, info)
+	}
+
+	truncated := data[:len(data)-4]
+	_, _, err = inspectScientificFormat(bytes.NewReader(truncated), int64(len(truncated)), "data.nc")
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("truncated file: got %v, want truncation error", err)
+	}
+
+	// A CDF-5 dimension count too large to allocate for.
+	corrupt := []byte("CDF\x05\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0a\xff\xff\xff\xff\xff\xff\xff\xff")
+	_, _, err = inspectScientificFormat(bytes.NewReader(corrupt), int64(len(corrupt)), "data.nc")
+	if err == nil || !strings.Contains(err.Error(), "implausible element count") {
+		t.Errorf("huge dimension count: got %v, want corrupt header error", err)
+	}
+}
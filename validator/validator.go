@@ -0,0 +1,473 @@
+// Package validator checks backup files for integrity. It walks any io/fs
+// file system, so backups can be validated on local disk, inside archives,
+// or in in-memory fixtures. Optional storage capabilities are described in
+// package backend.
+//
+//	v := validator.New(validator.WithRecheck(5 * time.Second))
+//	results := v.ValidateTree(ctx, backend.NewLocal("/backup/daily"))
+//	defer results.Close()
+//	for results.Next() {
+//		r := results.Result()
+//		fmt.Println(r.Status, r.Path)
+//	}
+//	if err := results.Err(); err != nil {
+//		log.Fatal(err)
+//	}
+package validator
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"backuptest/backend"
+)
+
+// Status is the outcome of validating one file.
+type Status string
+
+const (
+	// StatusOK means the file is valid and readable.
+	StatusOK Status = "OK"
+	// StatusWarning means the file exists but is empty.
+	StatusWarning Status = "WARNING"
+	// StatusFlaky means the file failed but passed when re-checked.
+	StatusFlaky Status = "FLAKY"
+	// StatusError means the file cannot be accessed or read, or its data
+	// format is damaged or truncated.
+	StatusError Status = "ERROR"
+)
+
+// Result is the outcome of validating one file.
+type Result struct {
+	// Path is the slash-separated path within the validated file system.
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum,omitempty"`
+	Format     string    `json:"format,omitempty"`
+	FormatInfo string    `json:"format_info,omitempty"`
+	Status     Status    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	TestTime   time.Time `json:"test_time"`
+
+	// ReadFailure is set when the file failed part-way through reading.
+	ReadFailure *ReadFailure `json:"read_failure,omitempty"`
+	// BadBlocks maps the read failure to device sectors, if requested.
+	BadBlocks *BadBlockReport `json:"bad_blocks,omitempty"`
+	// Members lists the names inside an archive file, if indexing is on.
+	Members []string `json:"members,omitempty"`
+	// RetainUntil and LegalHold are the WORM retention the storage reports.
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	LegalHold   bool       `json:"legal_hold,omitempty"`
+	// Encryption describes the recipients of an OpenPGP or age file.
+	Encryption *Encryption `json:"encryption,omitempty"`
+}
+
+// Validator validates files. A Validator is safe for concurrent use.
+type Validator struct {
+	hexdump      bool
+	badBlocks    bool
+	recheck      bool
+	recheckDelay time.Duration
+	index        bool
+
+	retention    bool
+	minRetention time.Duration
+
+	requireEncryption bool
+	retiredKeys       []string
+}
+
+// Option configures a Validator.
+type Option func(*Validator)
+
+// WithHexdump includes a hexdump of the bytes around a failing read offset
+// in the result. It needs random access: files that implement io.ReaderAt,
+// or a file system that implements backend.RangeFS.
+func WithHexdump() Option {
+	return func(v *Validator) { v.hexdump = true }
+}
+
+// WithBadBlocks maps read failures to device sectors and kernel I/O errors.
+// It is supported on Linux for file systems that report a local path
+// through backend.MetadataFS, such as backend.Local.
+func WithBadBlocks() Option {
+	return func(v *Validator) { v.badBlocks = true }
+}
+
+// WithRecheck re-validates failed files once, after delay, at the end of a
+// run. Files that pass on the second attempt are reported as StatusFlaky,
+// since transient storage errors (e.g. NFS timeouts) are not corruption.
+func WithRecheck(delay time.Duration) Option {
+	return func(v *Validator) {
+		v.recheck = true
+		v.recheckDelay = delay
+	}
+}
+
+// WithIndex records the member names of tar, tar.gz and zip archives in
+// Result.Members, so that archive contents can be searched later without
+// extracting them. Tar members are listed from the stream being hashed.
+func WithIndex() Option {
+	return func(v *Validator) { v.index = true }
+}
+
+// WithRetention requires every file to be WORM-protected by the storage
+// and retained for at least minimum from the time it is validated, or to be
+// under legal hold. It needs a file system that reports retention through
+// backend.MetadataFS, such as backend.SnapLock.
+func WithRetention(minimum time.Duration) Option {
+	return func(v *Validator) {
+		v.retention = true
+		v.minRetention = minimum
+	}
+}
+
+// WithRequiredEncryption fails every non-empty file that is not encrypted
+// with OpenPGP or age. Encryption is detected through random access, so
+// it needs files that implement io.ReaderAt or a backend.RangeFS.
+func WithRequiredEncryption() Option {
+	return func(v *Validator) { v.requireEncryption = true }
+}
+
+// WithRetiredKeys fails every file that is encrypted to one of keys, so
+// that backups still readable with a retired key are found and
+// re-encrypted. Keys are OpenPGP key IDs or fingerprints in hex, or age
+// recipients as recorded in Encryption.Keys.
+func WithRetiredKeys(keys ...string) Option {
+	return func(v *Validator) { v.retiredKeys = append(v.retiredKeys, keys...) }
+}
+
+// New returns a Validator configured by opts.
+func New(opts ...Option) *Validator {
+	v := &Validator{}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// ValidateTree validates every file in fsys. Results are produced as the
+// tree is walked; with WithRecheck, failed files are held back and reported
+// after they have been re-checked, or as they are if ctx is cancelled first.
+func (v *Validator) ValidateTree(ctx context.Context, fsys fs.FS) *Results {
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(chan Result)
+	it := &Results{ch: ch, cancel: cancel}
+
+	go func() {
+		defer close(ch)
+		// Results found before a cancellation are still sent: Next returns
+		// them, and Close drains them.
+		it.err = v.walk(ctx, fsys, func(r Result) bool {
+			ch <- r
+			return ctx.Err() == nil
+		})
+	}()
+	return it
+}
+
+// ValidateFile validates the single file name in fsys.
+func (v *Validator) ValidateFile(ctx context.Context, fsys fs.FS, name string) Result {
+	result := v.validateFile(ctx, fsys, name)
+	if v.recheck && result.Status == StatusError {
+		if results := v.recheckFailures(ctx, fsys, []Result{result}); len(results) > 0 {
+			result = results[0]
+		}
+	}
+	return result
+}
+
+func (v *Validator) walk(ctx context.Context, fsys fs.FS, emit func(Result) bool) error {
+	var failed []Result
+	report := func(r Result) bool {
+		if v.recheck && r.Status == StatusError {
+			failed = append(failed, r)
+			return true
+		}
+		return emit(r)
+	}
+
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if !report(Result{Path: path, Status: StatusError, Error: err.Error(), TestTime: time.Now()}) {
+				return ctx.Err()
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !report(v.validateFile(ctx, fsys, path)) {
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	// Held-back failures are reported even if the run was cancelled, so
+	// that an interrupted run does not lose its errors.
+	for _, r := range v.recheckFailures(ctx, fsys, failed) {
+		emit(r)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// recheckFailures re-validates failed results once after the recheck delay
+// and marks those that now pass cleanly (StatusOK) as StatusFlaky. If ctx is
+// cancelled, the results are returned as they are.
+func (v *Validator) recheckFailures(ctx context.Context, fsys fs.FS, failed []Result) []Result {
+	if len(failed) == 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return failed
+	case <-time.After(v.recheckDelay):
+	}
+
+	for i, r := range failed {
+		// Only a clean pass makes a failure flaky: a file that comes back
+		// empty, for example, is still reported as failed.
+		retry := v.validateFile(ctx, fsys, r.Path)
+		if retry.Status != StatusOK {
+			continue
+		}
+		retry.Status = StatusFlaky
+		retry.Error = "Passed on retry after: " + r.Error
+		retry.ReadFailure = r.ReadFailure
+		retry.BadBlocks = r.BadBlocks
+		failed[i] = retry
+	}
+	return failed
+}
+
+func (v *Validator) validateFile(ctx context.Context, fsys fs.FS, name string) Result {
+	result := Result{
+		Path:     name,
+		TestTime: time.Now(),
+	}
+	fail := func(err error) Result {
+		result.Status = StatusError
+		result.Error = err.Error()
+		return result
+	}
+
+	if ctx.Err() != nil {
+		return fail(errors.New("context cancelled"))
+	}
+
+	// Check file exists and is readable
+	file, err := fsys.Open(name)
+	if err != nil {
+		return fail(err)
+	}
+	defer file.Close()
+
+	// Get file size
+	info, err := file.Stat()
+	if err != nil {
+		return fail(err)
+	}
+	if info.IsDir() {
+		return fail(&fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")})
+	}
+	result.Size = info.Size()
+
+	meta, err := backend.StatMetadata(fsys, name)
+	if err != nil {
+		return fail(err)
+	}
+	ra := backend.ReaderAt(fsys, name, file)
+
+	var lister *memberLister
+	if v.index {
+		lister = newMemberLister(name)
+	}
+
+	// Calculate checksum
+	checksum, err := v.calculateChecksum(ctx, file, ra, result.Size, lister)
+	if lister != nil {
+		result.Members = lister.finish()
+	}
+	if err != nil {
+		var rerr *readError
+		if errors.As(err, &rerr) {
+			result.ReadFailure = &rerr.failure
+			if v.badBlocks {
+				result.BadBlocks = badBlockReport(meta, rerr.failure.Offset)
+			}
+		}
+		return fail(err)
+	}
+	result.Checksum = checksum
+
+	// Compare with the digest the storage recorded, if any
+	if meta.MD5 != "" && !strings.EqualFold(meta.MD5, checksum) {
+		return fail(fmt.Errorf("checksum mismatch: storage recorded MD5 %s", meta.MD5))
+	}
+
+	// Verify WORM retention
+	if meta.WORM {
+		result.LegalHold = meta.LegalHold
+		if !meta.RetainUntil.IsZero() {
+			retainUntil := meta.RetainUntil
+			result.RetainUntil = &retainUntil
+		}
+	}
+	if v.retention {
+		if err := v.checkRetention(meta); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Verify file integrity
+	if result.Size == 0 {
+		result.Status = StatusWarning
+		result.Error = "Empty file"
+		return result
+	}
+
+	if v.index && ra != nil && strings.HasSuffix(strings.ToLower(name), ".zip") {
+		result.Members = zipMembers(ra, result.Size)
+	}
+
+	// Identify the keys an encrypted file is readable with
+	if ra != nil {
+		result.Encryption = inspectEncryption(ra, result.Size)
+	}
+	if err := v.checkEncryption(result.Encryption); err != nil {
+		return fail(err)
+	}
+
+	// Validate the structure of known data formats
+	if ra != nil {
+		format, formatInfo, err := inspectScientificFormat(ra, result.Size, name)
+		if err != errNotScientific {
+			result.Format = format
+			result.FormatInfo = formatInfo
+			if err != nil {
+				return fail(err)
+			}
+		}
+	}
+	result.Status = StatusOK
+
+	return result
+}
+
+// checkRetention reports whether meta satisfies the retention requirement.
+func (v *Validator) checkRetention(meta backend.Metadata) error {
+	switch {
+	case !meta.WORM:
+		return errors.New("retention: file is not WORM-protected")
+	case meta.LegalHold:
+		return nil
+	case meta.RetainUntil.IsZero():
+		return errors.New("retention: storage does not report a retention date")
+	}
+	if required := time.Now().Add(v.minRetention); meta.RetainUntil.Before(required) {
+		return fmt.Errorf("retention: retained only until %s, required until at least %s",
+			meta.RetainUntil.Format(time.DateOnly), required.Format(time.DateOnly))
+	}
+	return nil
+}
+
+// checkEncryption records retired keys in enc and reports whether it
+// satisfies the encryption requirements.
+func (v *Validator) checkEncryption(enc *Encryption) error {
+	if enc == nil {
+		if v.requireEncryption {
+			return errors.New("encryption: file is not encrypted with OpenPGP or age")
+		}
+		return nil
+	}
+	for _, key := range enc.Keys {
+		for _, retired := range v.retiredKeys {
+			if isRetiredKey(key, retired) {
+				enc.Retired = append(enc.Retired, key)
+				break
+			}
+		}
+	}
+	if len(enc.Retired) > 0 {
+		return fmt.Errorf("encryption: file is encrypted to retired key %s", strings.Join(enc.Retired, ", "))
+	}
+	return nil
+}
+
+// badBlockReport maps a read failure to device sectors. Mapping errors are
+// recorded in the report rather than failing the result a second time.
+func badBlockReport(meta backend.Metadata, offset int64) *BadBlockReport {
+	if meta.LocalPath == "" {
+		return &BadBlockReport{Note: "bad-block mapping needs a file on local disk"}
+	}
+	file, err := os.Open(meta.LocalPath)
+	if err != nil {
+		return &BadBlockReport{Note: err.Error()}
+	}
+	defer file.Close()
+
+	report, err := mapBadBlocks(file, offset)
+	if err != nil {
+		return &BadBlockReport{Note: err.Error()}
+	}
+	return report
+}
+
+// calculateChecksum hashes the file from the start. A read failure, or the
+// file ending before its expected size, is reported as a *readError carrying
+// the failing offset. ra, if not nil, is used to hexdump around failures.
+// The data read is also copied to lister, if not nil.
+func (v *Validator) calculateChecksum(ctx context.Context, file fs.File, ra io.ReaderAt, size int64, lister *memberLister) (string, error) {
+	hash := md5.New()
+	var w io.Writer = hash
+	if lister != nil {
+		w = io.MultiWriter(hash, lister)
+	}
+	buf := make([]byte, 64*1024)
+	// Files with their own ReadAt are read by offset so a failed read does
+	// not leave the file position undefined; others are streamed.
+	fileAt, _ := file.(io.ReaderAt)
+	var offset int64
+	for {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		var n int
+		var err error
+		if fileAt != nil {
+			n, err = fileAt.ReadAt(buf, offset)
+		} else {
+			n, err = file.Read(buf)
+		}
+		w.Write(buf[:n])
+		offset += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", newReadError(ra, offset, err, v.hexdump)
+		}
+	}
+
+	if offset < size {
+		err := fmt.Errorf("file ended after %d of %d bytes: %w", offset, size, io.ErrUnexpectedEOF)
+		return "", newReadError(ra, offset, err, v.hexdump)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,342 @@
+package validator
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"backuptest/backend"
+)
+
+func collect(t *testing.T, v *Validator, fsys fs.FS) map[string]Result {
+	t.Helper()
+	results, err := v.ValidateTree(context.Background(), fsys).Collect()
+	if err != nil {
+		t.Fatalf("ValidateTree: %v", err)
+	}
+	byPath := make(map[string]Result)
+	for _, r := range results {
+		byPath[r.Path] = r
+	}
+	return byPath
+}
+
+func TestValidateTree(t *testing.T) {
+	fsys := fstest.MapFS{
+		"daily/db.sql":     {Data: []byte("CREATE TABLE t;")},
+		"daily/empty.log":  {Data: nil},
+		"science/grid.h5":  {Data: buildHDF5v0()},
+		"science/short.h5": {Data: buildHDF5v0()[:300]},
+	}
+	results := collect(t, New(), fsys)
+
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want 4", len(results))
+	}
+	tests := []struct {
+		path   string
+		status Status
+	}{
+		{"daily/db.sql", StatusOK},
+		{"daily/empty.log", StatusWarning},
+		{"science/grid.h5", StatusOK},
+		{"science/short.h5", StatusError},
+	}
+	for _, tt := range tests {
+		if got := results[tt.path].Status; got != tt.status {
+			t.Errorf("%s: status %s, want %s (%s)", tt.path, got, tt.status, results[tt.path].Error)
+		}
+	}
+	if r := results["daily/db.sql"]; r.Checksum != fmt.Sprintf("%x", md5.Sum([]byte("CREATE TABLE t;"))) {
+		t.Errorf("db.sql: checksum %q", r.Checksum)
+	}
+	if r := results["science/grid.h5"]; r.Format != "HDF5" {
+		t.Errorf("grid.h5: format %q, want HDF5", r.Format)
+	}
+}
+
+func TestValidateTreeZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("etc/hosts")
+	w.Write([]byte("127.0.0.1 localhost\n"))
+	zw.Close()
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := collect(t, New(), zr)
+	if r := results["etc/hosts"]; r.Status != StatusOK || r.Size != 20 {
+		t.Errorf("etc/hosts: got %+v", r)
+	}
+}
+
+// flakyFS fails the first read of each file with EIO.
+type flakyFS struct {
+	fstest.MapFS
+	failed map[string]bool
+}
+
+func (f flakyFS) Open(name string) (fs.File, error) {
+	file, err := f.MapFS.Open(name)
+	if err != nil || f.failed[name] {
+		return file, err
+	}
+	if info, _ := file.Stat(); info.IsDir() {
+		return file, nil
+	}
+	f.failed[name] = true
+	return failingFile{file}, nil
+}
+
+type failingFile struct{ fs.File }
+
+func (failingFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: "backup", Err: syscall.EIO}
+}
+
+func TestRecheck(t *testing.T) {
+	fsys := flakyFS{MapFS: fstest.MapFS{"a": {Data: []byte("data")}}, failed: map[string]bool{}}
+
+	r := collect(t, New(), fsys)["a"]
+	if r.Status != StatusError || r.ReadFailure == nil || r.ReadFailure.Errno != int(syscall.EIO) {
+		t.Errorf("without recheck: got %+v, want ERROR with EIO read failure", r)
+	}
+
+	r = collect(t, New(WithRecheck(0)), fsys)["a"]
+	if r.Status != StatusOK {
+		t.Errorf("second run: got %s, want OK", r.Status)
+	}
+
+	fsys.failed = map[string]bool{}
+	r = collect(t, New(WithRecheck(0)), fsys)["a"]
+	if r.Status != StatusFlaky || r.Checksum == "" || r.ReadFailure == nil {
+		t.Errorf("with recheck: got %+v, want FLAKY with checksum and first failure", r)
+	}
+
+	// A file that fails and then reads back empty has not passed.
+	fsys = flakyFS{MapFS: fstest.MapFS{"empty": {}}, failed: map[string]bool{}}
+	r = collect(t, New(WithRecheck(0)), fsys)["empty"]
+	if r.Status != StatusError {
+		t.Errorf("empty on recheck: got %s (%s), want ERROR", r.Status, r.Error)
+	}
+}
+
+// localFlakyFS is a flakyFS whose files also exist on local disk, so bad
+// blocks can be mapped.
+type localFlakyFS struct {
+	flakyFS
+	dir string
+}
+
+func (l localFlakyFS) Metadata(name string) (backend.Metadata, error) {
+	return backend.Metadata{LocalPath: filepath.Join(l.dir, name)}, nil
+}
+
+func TestBadBlocksKeepReadError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := localFlakyFS{flakyFS{MapFS: fstest.MapFS{"a": {Data: []byte("data")}}, failed: map[string]bool{}}, dir}
+
+	r := collect(t, New(WithBadBlocks()), fsys)["a"]
+	if r.Status != StatusError || !strings.Contains(r.Error, "input/output error") {
+		t.Errorf("got %s (%s), want the read error", r.Status, r.Error)
+	}
+	if r.BadBlocks == nil {
+		t.Error("expected a bad-block report")
+	}
+}
+
+func TestResultsCancel(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		fsys[name] = &fstest.MapFile{Data: []byte(name)}
+	}
+
+	it := New().ValidateTree(context.Background(), fsys)
+	if !it.Next() {
+		t.Fatal("expected a result")
+	}
+	it.Close()
+	if it.Next() {
+		t.Error("Next returned true after Close")
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("Err after Close = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := New().ValidateTree(ctx, fsys).Collect()
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled run: err = %v, want context.Canceled", err)
+	}
+}
+
+func TestResultsCancelKeepsFailures(t *testing.T) {
+	fsys := flakyFS{MapFS: fstest.MapFS{}, failed: map[string]bool{}}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		fsys.MapFS[name] = &fstest.MapFile{Data: []byte(name)}
+	}
+	// Only "a" fails; the others have already been read once.
+	for _, name := range []string{"b", "c", "d"} {
+		fsys.failed[name] = true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it := New(WithRecheck(time.Hour)).ValidateTree(ctx, fsys)
+	if !it.Next() {
+		t.Fatal("expected a result")
+	}
+	cancel()
+	results := map[string]Result{it.Result().Path: it.Result()}
+	for it.Next() {
+		results[it.Result().Path] = it.Result()
+	}
+	if !errors.Is(it.Err(), context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", it.Err())
+	}
+	it.Close()
+
+	if r, ok := results["a"]; !ok || r.Status != StatusError || r.ReadFailure == nil {
+		t.Errorf("a: got %+v, want the held-back ERROR", r)
+	}
+}
+
+// digestFS reports a stored MD5 for every file, as an object store would.
+type digestFS struct {
+	fstest.MapFS
+	digests map[string]string
+}
+
+func (d digestFS) Metadata(name string) (backend.Metadata, error) {
+	return backend.Metadata{MD5: d.digests[name]}, nil
+}
+
+func TestStoredDigest(t *testing.T) {
+	fsys := digestFS{
+		MapFS: fstest.MapFS{
+			"good": {Data: []byte("data")},
+			"bad":  {Data: []byte("date")},
+		},
+		digests: map[string]string{
+			"good": fmt.Sprintf("%X", md5.Sum([]byte("data"))),
+			"bad":  fmt.Sprintf("%x", md5.Sum([]byte("data"))),
+		},
+	}
+	results := collect(t, New(), fsys)
+	if r := results["good"]; r.Status != StatusOK {
+		t.Errorf("good: got %s (%s), want OK", r.Status, r.Error)
+	}
+	if r := results["bad"]; r.Status != StatusError || !strings.Contains(r.Error, "checksum mismatch") {
+		t.Errorf("bad: got %s (%s), want checksum mismatch", r.Status, r.Error)
+	}
+}
+
+// retentionFS reports WORM metadata for each file.
+type retentionFS struct {
+	fstest.MapFS
+	meta map[string]backend.Metadata
+}
+
+func (r retentionFS) Metadata(name string) (backend.Metadata, error) {
+	return r.meta[name], nil
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Now()
+	data := &fstest.MapFile{Data: []byte("data")}
+	fsys := retentionFS{
+		MapFS: fstest.MapFS{"long": data, "short": data, "held": data, "unknown": data, "open": data},
+		meta: map[string]backend.Metadata{
+			"long":    {WORM: true, RetainUntil: now.AddDate(8, 0, 0)},
+			"short":   {WORM: true, RetainUntil: now.AddDate(1, 0, 0)},
+			"held":    {WORM: true, RetainUntil: now.AddDate(-1, 0, 0), LegalHold: true},
+			"unknown": {WORM: true},
+		},
+	}
+
+	results := collect(t, New(WithRetention(7*365*24*time.Hour)), fsys)
+	for name, want := range map[string]string{
+		"long":    "",
+		"held":    "",
+		"short":   "retained only until",
+		"unknown": "does not report a retention date",
+		"open":    "not WORM-protected",
+	} {
+		r := results[name]
+		if want == "" {
+			if r.Status != StatusOK {
+				t.Errorf("%s: got %s (%s), want OK", name, r.Status, r.Error)
+			}
+			continue
+		}
+		if r.Status != StatusError || !strings.Contains(r.Error, want) {
+			t.Errorf("%s: got %s (%s), want error containing %q", name, r.Status, r.Error, want)
+		}
+	}
+	if r := results["long"]; r.RetainUntil == nil || !r.RetainUntil.Equal(fsys.meta["long"].RetainUntil) {
+		t.Errorf("long: RetainUntil = %v", r.RetainUntil)
+	}
+
+	// Without the requirement, retention is reported but not enforced.
+	if r := collect(t, New(), fsys)["open"]; r.Status != StatusOK {
+		t.Errorf("open without retention: got %s (%s), want OK", r.Status, r.Error)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	var tgz bytes.Buffer
+	gw := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"invoices/2023/Q4/a.pdf", "invoices/2023/Q4/b.pdf"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: 1})
+		tw.Write([]byte("x"))
+	}
+	tw.Close()
+	gw.Close()
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	zw.Create("etc/hosts")
+	zw.Close()
+
+	fsys := fstest.MapFS{
+		"files.tar.gz": {Data: tgz.Bytes()},
+		"files.zip":    {Data: zipped.Bytes()},
+		"broken.tgz":   {Data: tgz.Bytes()[:tgz.Len()/2]},
+	}
+
+	results := collect(t, New(WithIndex()), fsys)
+	if got := results["files.tar.gz"].Members; !reflect.DeepEqual(got, []string{"invoices/2023/Q4/a.pdf", "invoices/2023/Q4/b.pdf"}) {
+		t.Errorf("tar.gz members = %q", got)
+	}
+	if got := results["files.zip"].Members; !reflect.DeepEqual(got, []string{"etc/hosts"}) {
+		t.Errorf("zip members = %q", got)
+	}
+	if r := results["broken.tgz"]; r.Status != StatusOK || r.Checksum == "" {
+		t.Errorf("damaged archive should still be hashed: %+v", r)
+	}
+
+	if got := collect(t, New(), fsys)["files.zip"].Members; got != nil {
+		t.Errorf("members recorded without WithIndex: %q", got)
+	}
+}